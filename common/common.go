@@ -1,7 +1,9 @@
 package common
 
 import (
-	"sort"
+	"cmp"
+	"slices"
+	"strconv"
 )
 
 // StringInSlice checks if a string appears in a slice.
@@ -14,26 +16,34 @@ func StringInSlice(s string, sl []string) bool {
 	return false
 }
 
-// CompareStringSlices checks if two slices are equal.
-// It returns the number of different items.
-func CompareStringSlices(sl1, sl2 []string) int {
-	sort.Strings(sl1)
-	sort.Strings(sl2)
+// DiffStringSlices returns the items of sl2 that are not in sl1, and the items
+// of sl1 that are not in sl2. They are ports, returned in numerical order. The
+// slices passed are left untouched.
+func DiffStringSlices(sl1, sl2 []string) (added, removed []string) {
+	sl1 = slices.SortedFunc(slices.Values(sl1), comparePorts)
+	sl2 = slices.SortedFunc(slices.Values(sl2), comparePorts)
 
-	newports := []string{}
-	missingports := []string{}
+	added = []string{}
+	removed = []string{}
 
 	for _, v := range sl2 {
 		if !StringInSlice(v, sl1) {
-			newports = append(newports, v)
+			added = append(added, v)
 		}
 	}
 
 	for _, v := range sl1 {
 		if !StringInSlice(v, sl2) {
-			missingports = append(missingports, v)
+			removed = append(removed, v)
 		}
 	}
 
-	return len(newports) + len(missingports)
+	return added, removed
+}
+
+// comparePorts orders ports numerically.
+func comparePorts(a, b string) int {
+	pa, _ := strconv.Atoi(a)
+	pb, _ := strconv.Atoi(b)
+	return cmp.Compare(pa, pb)
 }
@@ -1,6 +1,8 @@
 package common
 
 import (
+	"reflect"
+	"slices"
 	"testing"
 )
 
@@ -25,32 +27,34 @@ func Test_StringInSlice(t *testing.T) {
 	}
 }
 
-func TestCompareStringSlices(t *testing.T) {
-
+func TestDiffStringSlices(t *testing.T) {
 	tests := []struct {
-		name string
-		sl1  []string
-		sl2  []string
-		want int
+		name        string
+		sl1         []string
+		sl2         []string
+		wantAdded   []string
+		wantRemoved []string
 	}{
-		{name: "same lists", sl1: []string{"1", "2", "3"}, sl2: []string{"1", "2", "3"}, want: 0},
-		{name: "different length1", sl1: []string{"1", "2", "3"}, sl2: []string{"1", "2", "3", "4"}, want: 1},
-		{name: "different length2", sl1: []string{"1", "2", "3", "4"}, sl2: []string{"1", "2", "3"}, want: 1},
-		{name: "same content, not same order", sl1: []string{"1", "2", "3"}, sl2: []string{"3", "2", "1"}, want: 0},
-		{name: "different lists1", sl1: []string{"1", "2", "3"}, sl2: []string{"4", "5", "6"}, want: 6},
-		{name: "different lists2", sl1: []string{"0", "1", "2", "3"}, sl2: []string{"4", "5", "6"}, want: 7},
+		{name: "same lists", sl1: []string{"1", "2"}, sl2: []string{"2", "1"}, wantAdded: []string{}, wantRemoved: []string{}},
+		{name: "added", sl1: []string{"1"}, sl2: []string{"1", "2"}, wantAdded: []string{"2"}, wantRemoved: []string{}},
+		{name: "removed", sl1: []string{"1", "2"}, sl2: []string{"2"}, wantAdded: []string{}, wantRemoved: []string{"1"}},
+		{name: "both", sl1: []string{"1", "2"}, sl2: []string{"2", "3"}, wantAdded: []string{"3"}, wantRemoved: []string{"1"}},
+		{name: "from nothing", sl1: nil, sl2: []string{"22"}, wantAdded: []string{"22"}, wantRemoved: []string{}},
+		{name: "numerical order", sl1: []string{"8080", "22", "1000", "443"}, sl2: []string{"9000", "443", "100"}, wantAdded: []string{"100", "9000"}, wantRemoved: []string{"22", "1000", "8080"}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := CompareStringSlices(tt.sl1, tt.sl2); got != tt.want {
-				t.Errorf("CompareStringSlices() = %v, want %v", got, tt.want)
+			sl1, sl2 := slices.Clone(tt.sl1), slices.Clone(tt.sl2)
+			added, removed := DiffStringSlices(sl1, sl2)
+			if !slices.Equal(sl1, tt.sl1) || !slices.Equal(sl2, tt.sl2) {
+				t.Errorf("DiffStringSlices() changed its arguments to %v and %v", sl1, sl2)
+			}
+			if !reflect.DeepEqual(added, tt.wantAdded) {
+				t.Errorf("DiffStringSlices() added = %v, want %v", added, tt.wantAdded)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("DiffStringSlices() removed = %v, want %v", removed, tt.wantRemoved)
 			}
 		})
 	}
 }
-
-func BenchmarkCompareStringSlices(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		CompareStringSlices([]string{"0", "1", "2", "3"}, []string{"4", "5", "6"})
-	}
-}
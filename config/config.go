@@ -1,37 +1,147 @@
 package config
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
-// Target holds an IP and a range of ports to scan
+// Target holds an IP and a range of ports to scan. IP can also be a CIDR or a
+// range of addresses, in which case every host is scanned except the ones in
+// Exclude. Host can be used instead of IP, it is resolved at each scan.
 type Target struct {
-	IP               string   `yaml:"ip"`
-	Name             string   `yaml:"name"`
-	Range            string   `yaml:"range"`
-	QueriesPerSecond int      `yaml:"queries_per_sec"`
-	TCP              protocol `yaml:"tcp"`
-	ICMP             protocol `yaml:"icmp"`
+	IP               string         `yaml:"ip"`
+	Host             string         `yaml:"host"`
+	Exclude          []string       `yaml:"exclude"`
+	Name             string         `yaml:"name"`
+	Range            string         `yaml:"range"`
+	QueriesPerSecond int            `yaml:"queries_per_sec"`
+	Banners          bool           `yaml:"banners"`
+	TCP              protocol       `yaml:"tcp"`
+	UDP              protocol       `yaml:"udp"`
+	ICMP             protocol       `yaml:"icmp"`
+	HTTP             HTTP           `yaml:"http"`
+	ExpectedHTTP     []ExpectedHTTP `yaml:"expected_http"`
+
+	// Source is where the target has been imported from, if it comes from a
+	// target file
+	Source string `yaml:"-"`
+}
+
+// HTTP configures the HTTP probe of a target. Its open TCP ports in Ports,
+// and the ones with an expected HTTP answer, are requested on Path when they
+// speak HTTP or TLS.
+type HTTP struct {
+	Ports string `yaml:"ports"`
+	Path  string `yaml:"path"`
+}
+
+// ExpectedHTTP is the answer expected from the HTTP probe of a port. Redirect
+// is a prefix of the redirect target, Server and Title substrings of the
+// Server header and page title. Empty fields are not checked.
+type ExpectedHTTP struct {
+	Port     int    `yaml:"port"`
+	Status   int    `yaml:"status"`
+	Redirect string `yaml:"redirect"`
+	Server   string `yaml:"server"`
+	Title    string `yaml:"title"`
+}
+
+// Notifications configures where changes in scan results are sent.
+type Notifications struct {
+	DedupWindow  string       `yaml:"dedup_window"`
+	Webhooks     []Webhook    `yaml:"webhooks"`
+	Alertmanager Alertmanager `yaml:"alertmanager"`
+}
+
+// Webhook is an URL receiving a POST request when scan results change. The
+// body is the JSON-encoded change, unless a template is given.
+type Webhook struct {
+	URL        string            `yaml:"url"`
+	Headers    map[string]string `yaml:"headers"`
+	Template   string            `yaml:"template"`
+	Timeout    string            `yaml:"timeout"`
+	MaxRetries int               `yaml:"max_retries"`
+}
+
+// Alertmanager lists the Alertmanager instances receiving alerts about
+// unexpected open and closed ports. Labels are added to every alert.
+type Alertmanager struct {
+	URLs           []string          `yaml:"urls"`
+	Labels         map[string]string `yaml:"labels"`
+	ResendInterval string            `yaml:"resend_interval"`
+}
+
+// Export is a file receiving the raw result of every scan, in the jsonl or csv
+// format. It is rotated when it would exceed MaxSize megabytes, or after
+// MaxAge. Only MaxBackups rotated files are kept.
+type Export struct {
+	Format     string `yaml:"format"`
+	Path       string `yaml:"path"`
+	MaxSize    int    `yaml:"max_size"`
+	MaxAge     string `yaml:"max_age"`
+	MaxBackups int    `yaml:"max_backups"`
+}
+
+// TLS configures the inspection of the TLS ports found by probes. Ports
+// accepting a protocol version older than MinVersion, like "1.2", are flagged
+// as weak.
+type TLS struct {
+	MinVersion string `yaml:"min_version"`
 }
 
+type storage struct {
+	Path          string `yaml:"path"`
+	HistoryPath   string `yaml:"history_path"`
+	HistoryLength int    `yaml:"history_length"`
+}
+
+// protocol holds the scan settings of a protocol. Expected TCP ports can be
+// followed by the service expected on them, like `443:tls,22:ssh`. Method is
+// how TCP ports are scanned, either connect or syn.
 type protocol struct {
 	Period   string `yaml:"period"`
 	Range    string `yaml:"range"`
 	Expected string `yaml:"expected"`
+	Method   string `yaml:"method"`
+}
+
+// ExpectedPorts returns the expected ports without their expected service, so
+// they can be used as a range.
+func (p protocol) ExpectedPorts() string {
+	specs := []string{}
+	for spec := range strings.SplitSeq(p.Expected, ",") {
+		spec, _, _ = strings.Cut(spec, ":")
+		specs = append(specs, spec)
+	}
+	return strings.Join(specs, ",")
 }
 
 // Conf holds configuration
 type Conf struct {
-	Timeout          int      `yaml:"timeout"`
-	Limit            int      `yaml:"limit"`
-	LogLevel         string   `yaml:"log_level"`
-	QueriesPerSecond int      `yaml:"queries_per_sec"`
-	TcpPeriod        string   `yaml:"tcp_period"`
-	IcmpPeriod       string   `yaml:"icmp_period"`
-	Targets          []Target `yaml:"targets"`
+	Timeout            int           `yaml:"timeout"`
+	Limit              int           `yaml:"limit"`
+	LogLevel           string        `yaml:"log_level"`
+	QueriesPerSecond   int           `yaml:"queries_per_sec"`
+	TcpPeriod          string        `yaml:"tcp_period"`
+	UdpPeriod          string        `yaml:"udp_period"`
+	IcmpPeriod         string        `yaml:"icmp_period"`
+	MaxHosts           int           `yaml:"max_hosts"`
+	PortStateMetrics   bool          `yaml:"port_state_metrics"`
+	PortStateLimit     int           `yaml:"port_state_limit"`
+	PortLatencyMetrics bool          `yaml:"port_latency_metrics"`
+	Banners            bool          `yaml:"banners"`
+	TLS                TLS           `yaml:"tls"`
+	Storage            storage       `yaml:"storage"`
+	Notifications      Notifications `yaml:"notifications"`
+	Exports            []Export      `yaml:"exports"`
+	Targets            []Target      `yaml:"targets"`
+	TargetFiles        []TargetFile  `yaml:"target_files"`
 }
 
 // New reads config from file and returns a config struct
@@ -52,5 +162,78 @@ func New(f string) (*Conf, error) {
 		return nil, err
 	}
 
+	if err := c.loadTargetFiles(filepath.Dir(f)); err != nil {
+		return nil, err
+	}
+
 	return &c, nil
 }
+
+// Watch polls the configuration file and its target files every interval, and
+// sends a notification on the returned channel when the modification time or
+// size of one of them changes. Polling is used instead of inotify so it also
+// works with files that are replaced by a symlink swap, like Kubernetes
+// ConfigMaps.
+func Watch(f string, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		last, _ := fingerprint(f)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			cur, err := fingerprint(f)
+			if err != nil {
+				// The file can briefly disappear while being replaced
+				continue
+			}
+			if cur == last {
+				continue
+			}
+			last = cur
+
+			// Do not block if a notification is already pending
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes
+}
+
+// fingerprint returns the modification time and size of the configuration
+// file and of its target files. Missing target files are part of the
+// fingerprint, so their creation is detected.
+func fingerprint(f string) (string, error) {
+	info, err := os.Stat(f)
+	if err != nil {
+		return "", err
+	}
+	fp := fmt.Sprint(info.ModTime().UnixNano(), info.Size())
+
+	// Only the target files are needed, errors are reported when loading
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return "", err
+	}
+	var c struct {
+		TargetFiles []TargetFile `yaml:"target_files"`
+	}
+	yaml.Unmarshal(b, &c)
+
+	for _, tf := range c.TargetFiles {
+		path := tf.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(f), path)
+		}
+		fp += " " + path
+		if info, err := os.Stat(path); err == nil {
+			fp += fmt.Sprint(":", info.ModTime().UnixNano(), ":", info.Size())
+		}
+	}
+
+	return fp, nil
+}
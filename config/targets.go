@@ -0,0 +1,212 @@
+package config
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TargetFile is a file listing targets, merged into the configured ones. The
+// format is csv or nmap-xml, guessed from the file extension if not set. Range
+// is the TCP range of the targets that do not have one. Targets without range
+// scan their expected ports. Relative paths are relative to the configuration
+// file.
+type TargetFile struct {
+	Path   string `yaml:"path"`
+	Format string `yaml:"format"`
+	Range  string `yaml:"range"`
+}
+
+// loadTargetFiles appends the targets of the target files to the configured
+// ones. dir is the directory of the configuration file.
+func (c *Conf) loadTargetFiles(dir string) error {
+	for _, tf := range c.TargetFiles {
+		targets, err := tf.load(dir)
+		if err != nil {
+			return err
+		}
+		c.Targets = append(c.Targets, targets...)
+	}
+	return nil
+}
+
+// load reads the targets of a target file.
+func (tf TargetFile) load(dir string) ([]Target, error) {
+	if tf.Path == "" {
+		return nil, fmt.Errorf("target file without path")
+	}
+	path := tf.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	format := tf.Format
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = "csv"
+		case ".xml":
+			format = "nmap-xml"
+		default:
+			return nil, fmt.Errorf("cannot guess the format of target file %s, set it to csv or nmap-xml", tf.Path)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var targets []Target
+	switch format {
+	case "csv":
+		targets, err = readCSVTargets(f, tf.Path)
+	case "nmap-xml":
+		targets, err = readNmapTargets(f, tf.Path)
+	default:
+		return nil, fmt.Errorf("unknown format %q for target file %s, must be csv or nmap-xml", format, tf.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		if targets[i].TCP.Range == "" {
+			targets[i].TCP.Range = tf.Range
+		}
+		if targets[i].TCP.Range == "" {
+			targets[i].TCP.Range = targets[i].TCP.ExpectedPorts()
+		}
+	}
+	return targets, nil
+}
+
+// readCSVTargets reads targets from CSV records of name, IP, TCP range and
+// expected TCP ports. Only name and IP are required. The first record is
+// skipped if it is a header, and lines starting with # are ignored.
+func readCSVTargets(r io.Reader, name string) ([]Target, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	targets := []Target{}
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read target file %s: %w", name, err)
+		}
+		line, _ := cr.FieldPos(0)
+
+		if first && strings.EqualFold(record[0], "name") {
+			continue
+		}
+		if len(record) < 2 || len(record) > 4 {
+			return nil, fmt.Errorf("%s:%d: want name, ip, range and expected, got %d fields", name, line, len(record))
+		}
+
+		// Missing fields are empty
+		record = append(record, "", "")
+		t := Target{Name: record[0], IP: record[1], Source: name + ":" + strconv.Itoa(line)}
+		t.TCP.Range = record[2]
+		t.TCP.Expected = record[3]
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// nmapRun holds the parts of nmap's XML output describing the targets.
+type nmapRun struct {
+	ScanInfo []struct {
+		Protocol string `xml:"protocol,attr"`
+		Services string `xml:"services,attr"`
+	} `xml:"scaninfo"`
+	Hosts []struct {
+		Status struct {
+			State string `xml:"state,attr"`
+		} `xml:"status"`
+		Addresses []struct {
+			Addr     string `xml:"addr,attr"`
+			AddrType string `xml:"addrtype,attr"`
+		} `xml:"address"`
+		Hostnames []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"hostnames>hostname"`
+		Ports []struct {
+			Protocol string `xml:"protocol,attr"`
+			PortID   int    `xml:"portid,attr"`
+			State    struct {
+				State string `xml:"state,attr"`
+			} `xml:"state"`
+		} `xml:"ports>port"`
+	} `xml:"host"`
+}
+
+// readNmapTargets reads targets from nmap's XML output. Each host that is up
+// becomes a target named after its first host name, or its IP. Its open TCP
+// ports are the expected ones, and the TCP ports scanned by nmap its range.
+func readNmapTargets(r io.Reader, name string) ([]Target, error) {
+	var run nmapRun
+	if err := xml.NewDecoder(r).Decode(&run); err != nil {
+		return nil, fmt.Errorf("cannot read target file %s: %w", name, err)
+	}
+
+	var scanned string
+	for _, si := range run.ScanInfo {
+		if si.Protocol == "tcp" {
+			scanned = si.Services
+		}
+	}
+
+	targets := []Target{}
+	for i, h := range run.Hosts {
+		if h.Status.State != "up" {
+			continue
+		}
+
+		t := Target{Source: fmt.Sprintf("%s host %d", name, i+1)}
+		for _, a := range h.Addresses {
+			if a.AddrType == "ipv4" || a.AddrType == "ipv6" {
+				t.IP = a.Addr
+				break
+			}
+		}
+		if t.IP == "" {
+			continue
+		}
+		t.Name = t.IP
+		if len(h.Hostnames) > 0 && h.Hostnames[0].Name != "" {
+			t.Name = h.Hostnames[0].Name
+		}
+
+		open := []int{}
+		for _, p := range h.Ports {
+			if p.Protocol == "tcp" && p.State.State == "open" {
+				open = append(open, p.PortID)
+			}
+		}
+		slices.Sort(open)
+		expected := []string{}
+		for _, p := range open {
+			expected = append(expected, strconv.Itoa(p))
+		}
+		t.TCP.Range = scanned
+		t.TCP.Expected = strings.Join(expected, ",")
+
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_readCSVTargets(t *testing.T) {
+	csv := `name,ip,range,expected
+# Comments are ignored
+web1, 10.0.0.1, "1-1024", "22,443"
+db1,10.0.0.2
+`
+	got, err := readCSVTargets(strings.NewReader(csv), "inventory.csv")
+	if err != nil {
+		t.Fatalf("readCSVTargets() error = %v", err)
+	}
+
+	want := []Target{
+		{Name: "web1", IP: "10.0.0.1", Source: "inventory.csv:3", TCP: protocol{Range: "1-1024", Expected: "22,443"}},
+		{Name: "db1", IP: "10.0.0.2", Source: "inventory.csv:4"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readCSVTargets() = %+v, want %+v", got, want)
+	}
+
+	if _, err := readCSVTargets(strings.NewReader("web1\n"), "inventory.csv"); err == nil {
+		t.Error("readCSVTargets() accepted a record without IP")
+	}
+}
+
+func Test_readNmapTargets(t *testing.T) {
+	xml := `<?xml version="1.0"?>
+<nmaprun scanner="nmap">
+  <scaninfo type="syn" protocol="tcp" numservices="1000" services="1-1000"/>
+  <host>
+    <status state="up"/>
+    <address addr="10.0.0.1" addrtype="ipv4"/>
+    <address addr="00:11:22:33:44:55" addrtype="mac"/>
+    <hostnames><hostname name="web1.example.com" type="PTR"/></hostnames>
+    <ports>
+      <port protocol="tcp" portid="443"><state state="open"/></port>
+      <port protocol="tcp" portid="22"><state state="open"/></port>
+      <port protocol="tcp" portid="25"><state state="closed"/></port>
+      <port protocol="udp" portid="53"><state state="open"/></port>
+    </ports>
+  </host>
+  <host>
+    <status state="down"/>
+    <address addr="10.0.0.2" addrtype="ipv4"/>
+  </host>
+  <host>
+    <status state="up"/>
+    <address addr="10.0.0.3" addrtype="ipv4"/>
+  </host>
+</nmaprun>`
+	got, err := readNmapTargets(strings.NewReader(xml), "scan.xml")
+	if err != nil {
+		t.Fatalf("readNmapTargets() error = %v", err)
+	}
+
+	want := []Target{
+		{Name: "web1.example.com", IP: "10.0.0.1", Source: "scan.xml host 1", TCP: protocol{Range: "1-1000", Expected: "22,443"}},
+		{Name: "10.0.0.3", IP: "10.0.0.3", Source: "scan.xml host 3", TCP: protocol{Range: "1-1000"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readNmapTargets() = %+v, want %+v", got, want)
+	}
+}
+
+func TestNew_targetFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("config.yaml", `timeout: 2
+targets:
+  - name: app1
+    ip: 10.0.0.1
+target_files:
+  - path: inventory.csv
+    range: reserved
+`)
+	write("inventory.csv", "web1,10.0.0.2\nweb2,10.0.0.3,,8080\n")
+
+	before, err := fingerprint(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ranges := []string{}
+	for _, t := range c.Targets {
+		ranges = append(ranges, t.Name+":"+t.TCP.Range)
+	}
+	want := []string{"app1:", "web1:reserved", "web2:reserved"}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("New() targets = %v, want %v", ranges, want)
+	}
+
+	// Changes of target files are detected
+	write("inventory.csv", "web1,10.0.0.2\n")
+	after, err := fingerprint(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("fingerprint() did not change with the target file")
+	}
+}
+
+func Test_protocol_ExpectedPorts(t *testing.T) {
+	tests := []struct {
+		expected string
+		want     string
+	}{
+		{expected: "", want: ""},
+		{expected: "22,443", want: "22,443"},
+		{expected: "443:tls,22:ssh,8080-8081:http", want: "443,22,8080-8081"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := (protocol{Expected: tt.expected}).ExpectedPorts(); got != tt.want {
+				t.Errorf("ExpectedPorts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
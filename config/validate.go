@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Error is an error found in a configuration file. Line is 0 when the
+// position of the error is unknown.
+type Error struct {
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Lines maps the path of each key of a configuration file, like
+// `targets[2].tcp.period`, to its line.
+type Lines map[string]int
+
+// Line returns the line of a path. If the path is not in the file, the line of
+// its closest parent is returned, or 0 if there is none.
+func (l Lines) Line(path string) int {
+	for path != "" {
+		if line, ok := l[path]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+// NewStrict reads a configuration file and its target files like New, but
+// rejects unknown keys. It returns all the errors found while decoding, and
+// the lines of the keys of the file.
+func NewStrict(f string) (*Conf, Lines, []*Error) {
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return nil, nil, []*Error{{Err: err}}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, nil, []*Error{{Err: err}}
+	}
+	lines := Lines{}
+	lines.walk("", &root)
+
+	c := Conf{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err = dec.Decode(&c)
+
+	errs := []*Error{}
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+	case errors.As(err, &typeErr):
+		// Decoding goes on after type errors, so all of them are reported
+		for _, msg := range typeErr.Errors {
+			errs = append(errs, parseYAMLError(msg))
+		}
+	default:
+		return nil, nil, []*Error{{Err: err}}
+	}
+
+	for i, tf := range c.TargetFiles {
+		targets, err := tf.load(filepath.Dir(f))
+		if err != nil {
+			errs = append(errs, &Error{Line: lines.Line(fmt.Sprintf("target_files[%d]", i)), Err: err})
+			continue
+		}
+		c.Targets = append(c.Targets, targets...)
+	}
+
+	return &c, lines, errs
+}
+
+// walk records the line of every key under node, path being the path of node.
+func (l Lines) walk(path string, node *yaml.Node) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			l.walk(path, n)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if path != "" {
+				key = path + "." + key
+			}
+			l[key] = node.Content[i].Line
+			l.walk(key, node.Content[i+1])
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			key := fmt.Sprintf("%s[%d]", path, i)
+			l[key] = n.Line
+			l.walk(key, n)
+		}
+	}
+}
+
+// parseYAMLError extracts the line from a YAML error message, formatted as
+// `line 12: message`.
+func parseYAMLError(msg string) *Error {
+	rest, ok := strings.CutPrefix(msg, "line ")
+	if !ok {
+		return &Error{Err: errors.New(msg)}
+	}
+	num, text, ok := strings.Cut(rest, ": ")
+	line, err := strconv.Atoi(num)
+	if !ok || err != nil {
+		return &Error{Err: errors.New(msg)}
+	}
+	return &Error{Line: line, Err: errors.New(text)}
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewStrict(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "config.yaml")
+	conf := `timeout: 2
+limit: 1024
+targets:
+  - name: app1
+    ip: 10.0.0.1
+    tcp:
+      period: 1h
+      ranges: 1-1024
+  - name: app2
+    queries_per_sec: many
+`
+	if err := os.WriteFile(f, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, lines, errs := NewStrict(f)
+	if c == nil {
+		t.Fatalf("NewStrict() returned no configuration, errors %v", errs)
+	}
+	if len(c.Targets) != 2 || c.Targets[0].TCP.Period != "1h" {
+		t.Errorf("NewStrict() = %+v, want the decoded configuration", c)
+	}
+
+	wantLines := []int{8, 10}
+	if len(errs) != len(wantLines) {
+		t.Fatalf("got errors %v, want %d errors", errs, len(wantLines))
+	}
+	for i, line := range wantLines {
+		if errs[i].Line != line {
+			t.Errorf("error %q is at line %d, want %d", errs[i].Err, errs[i].Line, line)
+		}
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "timeout", want: 1},
+		{path: "targets[0].tcp.period", want: 7},
+		{path: "targets[1]", want: 9},
+		// Missing keys are reported on their parent
+		{path: "targets[1].tcp.period", want: 9},
+		{path: "storage.path", want: 0},
+	}
+	for _, tt := range tests {
+		if got := lines.Line(tt.path); got != tt.want {
+			t.Errorf("Line(%q) = %d, want %d", tt.path, got, tt.want)
+		}
+	}
+}
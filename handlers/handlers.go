@@ -1,20 +1,27 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"net/netip"
 	"time"
 
+	"github.com/devops-works/scan-exporter/storage"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// HandleFunc fills the router.
-func HandleFunc() *mux.Router {
+// HandleFunc fills the router. The history API is only available if history
+// is not nil.
+func HandleFunc(history *storage.History) *mux.Router {
 	r := mux.NewRouter()
 	r.Handle("/metrics", promhttp.Handler())
 	r.Handle("/health", http.HandlerFunc(healthCheckPage))
+	if history != nil {
+		r.Handle("/api/targets/{name}/history", historyPage(history)).Methods(http.MethodGet)
+	}
 	r.NotFoundHandler = http.HandlerFunc(notFoundPage)
 
 	return r
@@ -37,6 +44,44 @@ func healthCheckPage(w http.ResponseWriter, r *http.Request) {
 }`, motd())
 }
 
+// historyPage handles the /api/targets/{name}/history page. The entries can be
+// filtered with the ip and proto query parameters. IPv6 addresses match
+// whatever their notation.
+func historyPage(history *storage.History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		ip := r.URL.Query().Get("ip")
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			ip = addr.String()
+		}
+		proto := r.URL.Query().Get("proto")
+
+		entries := []storage.Entry{}
+		for _, e := range history.Get(name) {
+			if (ip == "" || e.IP == ip) && (proto == "" || e.Proto == proto) {
+				entries = append(entries, e)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(entries) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("no history for target %s", name),
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(struct {
+			Name    string          `json:"name"`
+			History []storage.Entry `json:"history"`
+		}{
+			Name:    name,
+			History: entries,
+		})
+	}
+}
+
 func motd() string {
 	messages := []string{
 		"Who the f*ck is Jeff, and why does he have nuclear weapons ?",
@@ -5,6 +5,9 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/devops-works/scan-exporter/storage"
 )
 
 func Test_notFoundPage(t *testing.T) {
@@ -52,3 +55,45 @@ func Test_healthCheckPage(t *testing.T) {
 			rr.Body.String(), healthStatus)
 	}
 }
+
+func Test_historyPage(t *testing.T) {
+	history, err := storage.NewHistory("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history.Add("app1", storage.Entry{Time: time.Now(), IP: "10.0.0.1", Proto: "tcp", Open: []string{"22"}, Opened: []string{"22"}})
+	history.Add("app1", storage.Entry{Time: time.Now(), IP: "10.0.0.1", Proto: "udp", Open: []string{"53"}, Opened: []string{"53"}})
+	history.Add("app1", storage.Entry{Time: time.Now(), IP: "2001:db8::1", Proto: "tcp", Open: []string{"443"}, Opened: []string{"443"}})
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "target", url: "/api/targets/app1/history", wantStatus: http.StatusOK, wantBody: `"opened":["53"]`},
+		{name: "filtered", url: "/api/targets/app1/history?proto=tcp", wantStatus: http.StatusOK, wantBody: `"opened":["22"]`},
+		{name: "ipv6", url: "/api/targets/app1/history?ip=2001:DB8:0::1", wantStatus: http.StatusOK, wantBody: `"opened":["443"]`},
+		{name: "unknown target", url: "/api/targets/app2/history", wantStatus: http.StatusNotFound, wantBody: `"error"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			HandleFunc(history).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.wantStatus)
+			}
+			if !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					rr.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
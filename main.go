@@ -1,16 +1,23 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/devops-works/scan-exporter/config"
 	"github.com/devops-works/scan-exporter/logger"
 	"github.com/devops-works/scan-exporter/metrics"
+	"github.com/devops-works/scan-exporter/notifier"
 	"github.com/devops-works/scan-exporter/pprof"
 	"github.com/devops-works/scan-exporter/scan"
+	"github.com/devops-works/scan-exporter/sink"
+	"github.com/devops-works/scan-exporter/storage"
 	"github.com/rs/zerolog/log"
 )
 
@@ -22,18 +29,38 @@ var (
 )
 
 func main() {
-	if err := run(os.Args, os.Stdout); err != nil {
+	err := run(os.Args, os.Stdout)
+	if errors.Is(err, errUnexpectedPorts) {
+		os.Exit(2)
+	}
+	if errors.Is(err, errInvalidConfig) {
+		os.Exit(1)
+	}
+	if err != nil {
 		log.Fatal().Err(err).Msgf("error running %s", os.Args[0])
 		os.Exit(1)
 	}
 }
 
 func run(args []string, stdout io.Writer) error {
+	if len(args) > 1 {
+		switch args[1] {
+		case "scan":
+			return runScan(args[2:], stdout)
+		case "validate":
+			return runValidate(args[2:], stdout)
+		}
+	}
+
 	var confFile, pprofAddr, metricAddr, loglvl string
+	var watchInterval time.Duration
+	var once bool
 	flag.StringVar(&confFile, "config", "config.yaml", "path to config file")
+	flag.DurationVar(&watchInterval, "config.watch", 10*time.Second, "interval between config file change checks, 0 to disable")
 	flag.StringVar(&pprofAddr, "pprof.addr", "", "pprof addr")
 	flag.StringVar(&metricAddr, "metric.addr", ":2112", "metric server addr")
 	flag.StringVar(&loglvl, "log.lvl", "debug", "log level. Can be {trace,debug,info,warn,error,fatal}")
+	flag.BoolVar(&once, "once", false, "scan all the targets once, print the results and exit")
 	flag.Parse()
 
 	fmt.Printf("scan-exporter version %s (built %s)\n", Version, BuildDate)
@@ -62,13 +89,50 @@ func run(args []string, stdout io.Writer) error {
 		loglvl = c.LogLevel
 	}
 
+	if once {
+		return scanOnce(c, loglvl, nmapOutputs{}, stdout)
+	}
+
+	// Open the store of previous results
+	store, err := storage.Open(c.Storage.Path)
+	if err != nil {
+		log.Fatal().Msgf("error opening store %s: %s", c.Storage.Path, err)
+	}
+
+	// Open the scan history
+	history, err := storage.NewHistory(c.Storage.HistoryPath, c.Storage.HistoryLength)
+	if err != nil {
+		log.Fatal().Msgf("error opening history %s: %s", c.Storage.HistoryPath, err)
+	}
+
+	// Open the exports of scan results
+	sinks, err := sink.Open(c.Exports)
+	if err != nil {
+		log.Fatal().Msgf("error opening exports: %s", err)
+	}
+
 	// Create scanner
 	scanner := scan.Scanner{
-		Logger: logger.New(loglvl),
+		Logger:  logger.New(loglvl),
+		Store:   store,
+		History: history,
+		Sinks:   sinks,
+	}
+
+	// Create the notifiers
+	webhooks, err := notifier.NewWebhooks(c.Notifications)
+	if err != nil {
+		log.Fatal().Msgf("error configuring webhooks: %s", err)
+	}
+	alertmanager, err := notifier.NewAlertmanager(c.Notifications)
+	if err != nil {
+		log.Fatal().Msgf("error configuring Alertmanager: %s", err)
 	}
 
 	// Create metrics server
 	scanner.MetricsServ = *metrics.Init(metricAddr)
+	scanner.MetricsServ.History = history
+	scanner.MetricsServ.Notifiers = []notifier.Notifier{webhooks, alertmanager}
 
 	// Start metrics server
 	go func() {
@@ -77,8 +141,47 @@ func run(args []string, stdout io.Writer) error {
 		}
 	}()
 
+	// Reload the configuration on SIGHUP or when the file changes
+	go reloader(&scanner, webhooks, alertmanager, confFile, watchInterval)
+
 	if err := scanner.Start(c); err != nil {
 		return err
 	}
 	return nil
 }
+
+// reloader re-reads the configuration file and hands it to the scanner and the
+// notifiers when SIGHUP is received or when the file changes on disk.
+func reloader(scanner *scan.Scanner, webhooks *notifier.Webhooks, alertmanager *notifier.Alertmanager, confFile string, watchInterval time.Duration) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	// A nil channel blocks forever, which disables file watching
+	var changes <-chan struct{}
+	if watchInterval > 0 {
+		changes = config.Watch(confFile, watchInterval)
+	}
+
+	for {
+		select {
+		case <-sighup:
+			scanner.Logger.Info().Msg("SIGHUP received, reloading configuration")
+		case <-changes:
+			scanner.Logger.Info().Msgf("%s changed, reloading configuration", confFile)
+		}
+
+		c, err := config.New(confFile)
+		if err != nil {
+			scanner.Logger.Error().Err(err).Msgf("error reading %s, keeping the current configuration", confFile)
+			continue
+		}
+		scanner.Reload(c)
+
+		if err := webhooks.Configure(c.Notifications); err != nil {
+			scanner.Logger.Error().Err(err).Msg("cannot reload webhooks, keeping the current ones")
+		}
+		if err := alertmanager.Configure(c.Notifications); err != nil {
+			scanner.Logger.Error().Err(err).Msg("cannot reload Alertmanager, keeping the current settings")
+		}
+	}
+}
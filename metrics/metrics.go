@@ -1,44 +1,246 @@
 package metrics
 
 import (
+	"cmp"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/devops-works/scan-exporter/common"
 	"github.com/devops-works/scan-exporter/handlers"
+	"github.com/devops-works/scan-exporter/notifier"
+	"github.com/devops-works/scan-exporter/storage"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 )
 
-// Server is the metrics server. It contains all the Prometheus metrics
+// Server is the metrics server. It contains all the Prometheus metrics, the
+// scan history served by the API and the notifiers that are sent each scan
+// result.
 type Server struct {
 	Addr                                                    string
+	History                                                 *storage.History
+	Notifiers                                               []notifier.Notifier
 	NotRespondingList                                       map[string]bool
 	NumOfTargets, PendingScans, NumOfDownTargets, Uptime    prometheus.Gauge
 	UnexpectedPorts, OpenPorts, ClosedPorts, DiffPorts, Rtt *prometheus.GaugeVec
+	PortStates, PortState, PortStateDropped, PortErrors     *prometheus.GaugeVec
+	ScanProgress, ScanSize, LastScanStart, LastScanSuccess  *prometheus.GaugeVec
+	ScanDuration, ConnectDuration                           *prometheus.HistogramVec
+	PortConnectDuration                                     *prometheus.GaugeVec
+	TargetInfo, SYNFallback                                 *prometheus.GaugeVec
+	DNSChanges, PortChanges                                 *prometheus.CounterVec
+	UnexpectedServices                                      *prometheus.GaugeVec
+	TLSCertExpiry, TLSInfos, TLSWeakVersion                 *prometheus.GaugeVec
+	HTTPStatus, HTTPResponseTime, HTTPInfos, HTTPUnexpected *prometheus.GaugeVec
+
+	// portStateSeries holds the per-port series of each target and protocol,
+	// with the value of their expected label
+	portStateSeries map[string]map[string]string
 }
 
+// Port states, as reported by the scanner.
+const (
+	// StateOpen is a port that accepted a connection or answered a probe.
+	StateOpen = "open"
+	// StateClosed is a port that actively refused the connection (TCP RST or
+	// ICMP port unreachable).
+	StateClosed = "closed"
+	// StateFiltered is a TCP port that did not answer before the timeout.
+	StateFiltered = "filtered"
+	// StateOpenFiltered is a UDP port that did not answer. It can be open or
+	// filtered.
+	StateOpenFiltered = "open|filtered"
+	// StateError is a port that could not be scanned, for example because
+	// the host is unreachable.
+	StateError = "error"
+)
+
+// States lists all the port states.
+var States = []string{StateOpen, StateClosed, StateFiltered, StateOpenFiltered, StateError}
+
+// Reasons why a port is not open.
+const (
+	// ReasonRefused is a connection refused by a TCP RST, or a UDP probe
+	// answered by an ICMP port unreachable.
+	ReasonRefused = "refused"
+	// ReasonTimeout is a port that did not answer before the timeout.
+	ReasonTimeout = "timeout"
+	// ReasonHostUnreachable is a host that could not be reached.
+	ReasonHostUnreachable = "host_unreachable"
+	// ReasonNetworkUnreachable is a network that could not be reached.
+	ReasonNetworkUnreachable = "network_unreachable"
+	// ReasonPermission is a connection forbidden by a firewall rule of the
+	// local host, or by a remote one answering that it is administratively
+	// prohibited.
+	ReasonPermission = "permission"
+	// ReasonOther is any other error.
+	ReasonOther = "other"
+)
+
 // NewMetrics is the type that will transit between scan and metrics. It carries
 // informations that will be used for calculation, such as expected ports.
+// Previous holds the open ports of the previous scan, Opened and Closed the
+// ports that were opened and closed since then. Previous is nil when there is
+// no previous scan to compare to, and then nothing was opened or closed. Ports
+// holds the scanned ports by state, and Errors the number of ports in error by
+// reason. Services holds the services identified on open ports and
+// ExpectedServices the services expected on them, by port. TLS holds what has
+// been learned from the TLS ports, and HTTP the answers of the HTTP probes, by
+// port. Latencies holds the time it took to connect to the open TCP ports, by
+// port. PortStateLimit is the maximum number of per-port series for the target,
+// 0 disables them, and PortLatency enables the per-port connect time series of
+// the expected ports. When Removed is set, the target is gone from the
+// configuration and all its series are deleted. If Proto is also set, the
+// target is only not scanned using it anymore, and only its series are.
 type NewMetrics struct {
-	Name     string
-	IP       string
-	Diff     int
-	Open     []string
-	Closed   []string
-	Expected []string
+	Name             string
+	IP               string
+	Proto            string
+	Previous         []string
+	Opened           []string
+	Closed           []string
+	Ports            map[string][]string
+	Errors           map[string]int
+	Expected         []string
+	Services         map[string]string
+	ExpectedServices map[string]string
+	TLS              map[string]TLSInfo
+	HTTP             map[string]HTTPInfo
+	Latencies        map[string]time.Duration
+	PortStateLimit   int
+	PortLatency      bool
+	Removed          bool
+}
+
+// Unexpected returns the open ports that are not expected, and the expected
+// ports that are not open. Ports that can be open but did not answer are not
+// considered closed.
+func (nm NewMetrics) Unexpected() (open, closed []string) {
+	for _, port := range nm.Ports[StateOpen] {
+		if !common.StringInSlice(port, nm.Expected) {
+			open = append(open, port)
+		}
+	}
+	for _, port := range nm.Expected {
+		if !common.StringInSlice(port, nm.Ports[StateOpen]) && !common.StringInSlice(port, nm.Ports[StateOpenFiltered]) {
+			closed = append(closed, port)
+		}
+	}
+	return open, closed
+}
+
+// UnexpectedServices returns the open ports that are not running the service
+// expected on them, formatted as port:service, like `443:http`. Ports where no
+// known service was found are reported as port:unknown.
+func (nm NewMetrics) UnexpectedServices() []string {
+	unexpected := []string{}
+	for _, port := range nm.Ports[StateOpen] {
+		want, ok := nm.ExpectedServices[port]
+		if !ok {
+			continue
+		}
+		if got := nm.Services[port]; got != want {
+			unexpected = append(unexpected, port+":"+cmp.Or(got, "unknown"))
+		}
+	}
+	slices.SortFunc(unexpected, func(a, b string) int {
+		pa, _ := strconv.Atoi(strings.Split(a, ":")[0])
+		pb, _ := strconv.Atoi(strings.Split(b, ":")[0])
+		return cmp.Compare(pa, pb)
+	})
+	return unexpected
 }
 
-// PingInfo holds the ping update of a specific target
+// TLSInfo is what has been learned from a TLS handshake with a port. Version
+// and Cipher are the negotiated ones, and the certificate fields describe the
+// leaf certificate. Weak is set when the port accepts a protocol version older
+// than the configured minimum.
+type TLSInfo struct {
+	Version  string
+	Cipher   string
+	Issuer   string
+	Subject  string
+	SANs     []string
+	NotAfter time.Time
+	Weak     bool
+}
+
+// HTTPInfo is the answer to the HTTP probe of a port. Location is the
+// redirect target, if any. Error is set when no answer was received, and
+// Unexpected describes the differences with the expected answer.
+type HTTPInfo struct {
+	Scheme       string
+	Status       int
+	Location     string
+	Server       string
+	Title        string
+	ResponseTime time.Duration
+	Error        string
+	Unexpected   []string
+}
+
+// PingInfo holds the ping update of a specific target. When Stopped is set,
+// the target is no longer pinged and its ping series are deleted.
 type PingInfo struct {
 	Name         string
 	IP           string
 	IsResponding bool
 	RTT          time.Duration
+	Stopped      bool
 }
 
 // Init initialize the metrics
 func Init(addr string) *Server {
+	s := newServer()
+
+	prometheus.MustRegister(
+		s.NumOfTargets,
+		s.PendingScans,
+		s.Uptime,
+		s.NumOfDownTargets,
+		s.UnexpectedPorts,
+		s.OpenPorts,
+		s.ClosedPorts,
+		s.DiffPorts,
+		s.PortChanges,
+		s.PortStates,
+		s.PortState,
+		s.PortStateDropped,
+		s.ScanDuration,
+		s.ConnectDuration,
+		s.PortConnectDuration,
+		s.ScanProgress,
+		s.ScanSize,
+		s.LastScanStart,
+		s.LastScanSuccess,
+		s.Rtt,
+		s.TargetInfo,
+		s.SYNFallback,
+		s.DNSChanges,
+		s.UnexpectedServices,
+		s.TLSCertExpiry,
+		s.TLSInfos,
+		s.TLSWeakVersion,
+		s.PortErrors,
+		s.HTTPStatus,
+		s.HTTPResponseTime,
+		s.HTTPInfos,
+		s.HTTPUnexpected,
+	)
+
+	s.Addr = addr
+
+	// Start uptime counter
+	go s.uptimeCounter()
+
+	return s
+}
+
+// newServer creates the metrics, without registering them.
+func newServer() *Server {
 	s := Server{
 		NumOfTargets: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "scanexporter_targets_number_total",
@@ -62,47 +264,150 @@ func Init(addr string) *Server {
 		UnexpectedPorts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "scanexporter_unexpected_open_ports_total",
 			Help: "Number of ports that are open, and shouldn't be.",
-		}, []string{"name", "ip"}),
+		}, []string{"name", "ip", "proto"}),
 		OpenPorts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "scanexporter_open_ports_total",
 			Help: "Number of ports that are open.",
-		}, []string{"name", "ip"}),
+		}, []string{"name", "ip", "proto"}),
 
 		ClosedPorts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "scanexporter_unexpected_closed_ports_total",
 			Help: "Number of ports that are closed and shouldn't be.",
-		}, []string{"name", "ip"}),
+		}, []string{"name", "ip", "proto"}),
 
 		DiffPorts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "scanexporter_diff_ports_total",
 			Help: "Number of ports that are different from previous scan.",
+		}, []string{"name", "ip", "proto"}),
+
+		PortChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scanexporter_port_changes_total",
+			Help: "Number of ports opened or closed between two scans.",
+		}, []string{"name", "ip", "proto", "change"}),
+
+		PortStates: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_ports_total",
+			Help: "Number of scanned ports in each state.",
+		}, []string{"name", "ip", "proto", "state"}),
+
+		PortState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_port_state",
+			Help: "1 if the port is open, 0 if it is expected but not open.",
+		}, []string{"name", "ip", "port", "proto", "expected"}),
+
+		PortStateDropped: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_port_state_dropped",
+			Help: "Number of ports left out of scanexporter_port_state because of the series limit.",
+		}, []string{"name", "ip", "proto"}),
+
+		ScanDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "scanexporter_scan_duration_seconds",
+			Help: "Duration of complete scans.",
+			// From 1s to about 4h30
+			Buckets: prometheus.ExponentialBuckets(1, 2, 15),
+		}, []string{"name", "ip", "proto"}),
+
+		ConnectDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "scanexporter_connect_duration_seconds",
+			Help: "Time it took to connect to the open TCP ports.",
+			// From 500us to about 4s
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
 		}, []string{"name", "ip"}),
 
+		PortConnectDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_port_connect_duration_seconds",
+			Help: "Time it took to connect to an open expected TCP port during the last scan.",
+		}, []string{"name", "ip", "port"}),
+
+		ScanProgress: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_scan_progress_ports",
+			Help: "Number of ports scanned so far in the current or last scan.",
+		}, []string{"name", "ip", "proto"}),
+
+		ScanSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_scan_size_ports",
+			Help: "Number of ports to scan in the current or last scan.",
+		}, []string{"name", "ip", "proto"}),
+
+		LastScanStart: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_last_scan_start_timestamp_seconds",
+			Help: "Timestamp of the start of the last scan.",
+		}, []string{"name", "ip", "proto"}),
+
+		LastScanSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_last_scan_success_timestamp_seconds",
+			Help: "Timestamp of the end of the last complete scan.",
+		}, []string{"name", "ip", "proto"}),
+
 		Rtt: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "scanexporter_rtt_total",
 			Help: "Response time of the target.",
 		}, []string{"name", "ip"}),
-	}
 
-	prometheus.MustRegister(
-		s.NumOfTargets,
-		s.PendingScans,
-		s.Uptime,
-		s.NumOfDownTargets,
-		s.UnexpectedPorts,
-		s.OpenPorts,
-		s.ClosedPorts,
-		s.DiffPorts,
-		s.Rtt,
-	)
+		TargetInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_target_info",
+			Help: "Addresses a host target resolved to during its last scan.",
+		}, []string{"name", "host", "ip"}),
 
-	s.Addr = addr
+		SYNFallback: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_syn_fallback",
+			Help: "1 if a target asking for SYN scans was connect scanned because raw sockets are unavailable.",
+		}, []string{"name", "ip"}),
 
-	// Initialize the map
-	s.NotRespondingList = make(map[string]bool)
+		DNSChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scanexporter_dns_changes_total",
+			Help: "Number of times the resolved addresses of a host target changed.",
+		}, []string{"name", "host"}),
 
-	// Start uptime counter
-	go s.uptimeCounter()
+		UnexpectedServices: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_unexpected_service_total",
+			Help: "Number of open TCP ports not running the service expected on them.",
+		}, []string{"name", "ip"}),
+
+		TLSCertExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_tls_cert_expiry_seconds",
+			Help: "Timestamp of the expiry of the certificate of a TLS port.",
+		}, []string{"name", "ip", "port"}),
+
+		TLSInfos: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_tls_info",
+			Help: "Negotiated protocol version and cipher, and certificate of a TLS port.",
+		}, []string{"name", "ip", "port", "version", "cipher", "issuer", "subject", "sans"}),
+
+		TLSWeakVersion: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_tls_weak_version",
+			Help: "1 if a TLS port accepts a protocol version older than the configured minimum.",
+		}, []string{"name", "ip", "port"}),
+
+		PortErrors: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_port_errors",
+			Help: "Number of ports of the last scan of a target that could not be scanned, by reason.",
+		}, []string{"name", "ip", "proto", "reason"}),
+
+		HTTPStatus: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_http_status_code",
+			Help: "Status code of the answer to the HTTP probe of a port.",
+		}, []string{"name", "ip", "port"}),
+
+		HTTPResponseTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_http_response_seconds",
+			Help: "Time to the answer to the HTTP probe of a port.",
+		}, []string{"name", "ip", "port"}),
+
+		HTTPInfos: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_http_info",
+			Help: "Scheme, redirect target, Server header and page title of the answer to the HTTP probe of a port.",
+		}, []string{"name", "ip", "port", "scheme", "location", "server", "title"}),
+
+		HTTPUnexpected: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scanexporter_http_unexpected",
+			Help: "Number of differences between the answer to the HTTP probe of a port and the expected one.",
+		}, []string{"name", "ip", "port"}),
+	}
+
+	// Initialize the maps
+	s.NotRespondingList = make(map[string]bool)
+	s.portStateSeries = make(map[string]map[string]string)
 
 	return &s
 }
@@ -111,7 +416,7 @@ func Init(addr string) *Server {
 func (s *Server) Start() error {
 	srv := &http.Server{
 		Addr:         s.Addr,
-		Handler:      handlers.HandleFunc(),
+		Handler:      handlers.HandleFunc(s.History),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
@@ -120,47 +425,93 @@ func (s *Server) Start() error {
 
 // Updater updates metrics
 func (s *Server) Updater(metChan chan NewMetrics, pingChan chan PingInfo, pending chan int) {
-	var unexpectedPorts, closedPorts []string
 	for {
 		select {
 		case nm := <-metChan:
+			if nm.Removed {
+				s.Forget(nm.Name, nm.IP, nm.Proto)
+				continue
+			}
+
 			// New metrics set has been receievd
 
-			s.DiffPorts.WithLabelValues(nm.Name, nm.IP).Set(float64(nm.Diff))
-			log.Info().Str("name", nm.Name).Str("ip", nm.IP).Msgf("%s (%s) open ports: %s", nm.Name, nm.IP, nm.Open)
+			open := nm.Ports[StateOpen]
+
+			s.DiffPorts.WithLabelValues(nm.Name, nm.IP, nm.Proto).Set(float64(len(nm.Opened) + len(nm.Closed)))
+			s.PortChanges.WithLabelValues(nm.Name, nm.IP, nm.Proto, "opened").Add(float64(len(nm.Opened)))
+			s.PortChanges.WithLabelValues(nm.Name, nm.IP, nm.Proto, "closed").Add(float64(len(nm.Closed)))
+			if len(nm.Opened)+len(nm.Closed) > 0 {
+				log.Warn().Str("name", nm.Name).Str("ip", nm.IP).Str("proto", nm.Proto).Strs("opened", nm.Opened).Strs("closed", nm.Closed).
+					Msgf("%s (%s) %s ports changed since previous scan: opened %s, closed %s", nm.Name, nm.IP, nm.Proto, nm.Opened, nm.Closed)
+			}
+			log.Info().Str("name", nm.Name).Str("ip", nm.IP).Str("proto", nm.Proto).Msgf("%s (%s) open %s ports: %s", nm.Name, nm.IP, nm.Proto, open)
 
-			s.OpenPorts.WithLabelValues(nm.Name, nm.IP).Set(float64(len(nm.Open)))
+			s.OpenPorts.WithLabelValues(nm.Name, nm.IP, nm.Proto).Set(float64(len(open)))
+			s.updatePortStates(nm)
 
-			// If the port is open but not expected
-			for _, port := range nm.Open {
-				if !common.StringInSlice(port, nm.Expected) {
-					unexpectedPorts = append(unexpectedPorts, port)
-				}
+			for _, state := range States {
+				s.PortStates.WithLabelValues(nm.Name, nm.IP, nm.Proto, state).Set(float64(len(nm.Ports[state])))
 			}
-			s.UnexpectedPorts.WithLabelValues(nm.Name, nm.IP).Set(float64(len(unexpectedPorts)))
+			log.Debug().Str("name", nm.Name).Str("ip", nm.IP).Str("proto", nm.Proto).
+				Int("closed", len(nm.Ports[StateClosed])).
+				Int("filtered", len(nm.Ports[StateFiltered])).
+				Int("open_filtered", len(nm.Ports[StateOpenFiltered])).
+				Int("error", len(nm.Ports[StateError])).
+				Msgf("%s (%s) %s ports states", nm.Name, nm.IP, nm.Proto)
+			s.PortErrors.DeletePartialMatch(prometheus.Labels{"name": nm.Name, "ip": nm.IP, "proto": nm.Proto})
+			for reason, count := range nm.Errors {
+				s.PortErrors.WithLabelValues(nm.Name, nm.IP, nm.Proto, reason).Set(float64(count))
+			}
+
+			unexpectedPorts, closedPorts := nm.Unexpected()
+			s.UnexpectedPorts.WithLabelValues(nm.Name, nm.IP, nm.Proto).Set(float64(len(unexpectedPorts)))
 			if len(unexpectedPorts) > 0 {
-				log.Warn().Str("name", nm.Name).Str("ip", nm.IP).Msgf("%s (%s) unexpected open ports: %s", nm.Name, nm.IP, unexpectedPorts)
+				log.Warn().Str("name", nm.Name).Str("ip", nm.IP).Str("proto", nm.Proto).Msgf("%s (%s) unexpected open %s ports: %s", nm.Name, nm.IP, nm.Proto, unexpectedPorts)
 			} else {
-				log.Info().Str("name", nm.Name).Str("ip", nm.IP).Msgf("%s (%s) unexpected open ports: %s", nm.Name, nm.IP, unexpectedPorts)
+				log.Info().Str("name", nm.Name).Str("ip", nm.IP).Str("proto", nm.Proto).Msgf("%s (%s) unexpected open %s ports: %s", nm.Name, nm.IP, nm.Proto, unexpectedPorts)
 			}
 
-			unexpectedPorts = nil
-
-			// If the port is expected but not open
-			for _, port := range nm.Expected {
-				if !common.StringInSlice(port, nm.Open) {
-					closedPorts = append(closedPorts, port)
-				}
-			}
-			s.ClosedPorts.WithLabelValues(nm.Name, nm.IP).Set(float64(len(closedPorts)))
+			s.ClosedPorts.WithLabelValues(nm.Name, nm.IP, nm.Proto).Set(float64(len(closedPorts)))
 			if len(closedPorts) > 0 {
-				log.Warn().Str("name", nm.Name).Str("ip", nm.IP).Msgf("%s (%s) unexpected closed ports: %s", nm.Name, nm.IP, closedPorts)
+				log.Warn().Str("name", nm.Name).Str("ip", nm.IP).Str("proto", nm.Proto).Msgf("%s (%s) unexpected closed %s ports: %s", nm.Name, nm.IP, nm.Proto, closedPorts)
 			} else {
-				log.Info().Str("name", nm.Name).Str("ip", nm.IP).Msgf("%s (%s) unexpected closed ports: %s", nm.Name, nm.IP, closedPorts)
+				log.Info().Str("name", nm.Name).Str("ip", nm.IP).Str("proto", nm.Proto).Msgf("%s (%s) unexpected closed %s ports: %s", nm.Name, nm.IP, nm.Proto, closedPorts)
+			}
+
+			// Services are only expected on TCP ports
+			if nm.Proto == "tcp" {
+				services := nm.UnexpectedServices()
+				s.UnexpectedServices.WithLabelValues(nm.Name, nm.IP).Set(float64(len(services)))
+				if len(services) > 0 {
+					log.Warn().Str("name", nm.Name).Str("ip", nm.IP).Strs("services", services).
+						Msgf("%s (%s) unexpected services on tcp ports: %s", nm.Name, nm.IP, services)
+				}
+				s.updateLatencies(nm)
+				s.updateTLS(nm)
+				s.updateHTTP(nm)
 			}
 
-			closedPorts = nil
+			event := notifier.Event{
+				Time:             time.Now(),
+				Name:             nm.Name,
+				IP:               nm.IP,
+				Proto:            nm.Proto,
+				Open:             open,
+				Previous:         nm.Previous,
+				Opened:           nm.Opened,
+				Closed:           nm.Closed,
+				UnexpectedOpen:   unexpectedPorts,
+				UnexpectedClosed: closedPorts,
+			}
+			for _, n := range s.Notifiers {
+				n.Notify(event)
+			}
 		case pm := <-pingChan:
+			if pm.Stopped {
+				s.ForgetPing(pm.Name, pm.IP)
+				continue
+			}
+
 			log.Debug().Str("name", pm.Name).Str("ip", pm.IP).Msg("received new ping result")
 
 			// New ping metric has been received
@@ -173,26 +524,27 @@ func (s *Server) Updater(metChan chan NewMetrics, pingChan chan PingInfo, pendin
 			// Update target's RTT metric
 			s.Rtt.WithLabelValues(pm.Name, pm.IP).Set(float64(pm.RTT))
 
-			// Check if the IP is already in the map.
-			_, ok := s.NotRespondingList[pm.IP]
+			// Check if the target's IP is already in the map.
+			key := pingKey(pm.Name, pm.IP)
+			_, ok := s.NotRespondingList[key]
 			if !ok {
 				// If not, add it as responding.
-				s.NotRespondingList[pm.IP] = false
+				s.NotRespondingList[key] = false
 			}
 
 			// Check if the target didn't respond in the previous scan.
-			alreadyNotResponding := s.NotRespondingList[pm.IP]
+			alreadyNotResponding := s.NotRespondingList[key]
 
 			if pm.IsResponding && alreadyNotResponding {
 				// Wasn't responding, but now is ok
 				s.NumOfDownTargets.Dec()
-				s.NotRespondingList[pm.IP] = false
+				s.NotRespondingList[key] = false
 
 			} else if !pm.IsResponding && !alreadyNotResponding {
 				// First time it doesn't respond.
 				// Increment the number of down targets.
 				s.NumOfDownTargets.Inc()
-				s.NotRespondingList[pm.IP] = true
+				s.NotRespondingList[key] = true
 			}
 			// Else, everything is good, do nothing or everything is as bad as it was, so do nothing too.
 		case pending := <-pending:
@@ -204,6 +556,124 @@ func (s *Server) Updater(metChan chan NewMetrics, pingChan chan PingInfo, pendin
 	}
 }
 
+// Forget deletes all the series of a target, so removed targets do not linger
+// in dashboards. When proto is set, only the series of this protocol are
+// deleted, as the target is still scanned using the other one. It must be
+// called from the Updater goroutine.
+func (s *Server) Forget(name, ip, proto string) {
+	log.Debug().Str("name", name).Str("ip", ip).Str("proto", proto).Msg("deleting target metrics")
+
+	labels := prometheus.Labels{"name": name, "ip": ip}
+	if proto != "" {
+		labels["proto"] = proto
+	}
+	for _, vec := range []*prometheus.GaugeVec{
+		s.UnexpectedPorts, s.OpenPorts, s.ClosedPorts, s.DiffPorts, s.PortStates, s.PortState, s.PortStateDropped,
+		s.ScanProgress, s.ScanSize, s.LastScanStart, s.LastScanSuccess, s.PortErrors,
+	} {
+		vec.DeletePartialMatch(labels)
+	}
+	s.ScanDuration.DeletePartialMatch(labels)
+	s.PortChanges.DeletePartialMatch(labels)
+	for key := range s.portStateSeries {
+		if key == name+"/"+ip+"/"+proto || proto == "" && strings.HasPrefix(key, name+"/"+ip+"/") {
+			delete(s.portStateSeries, key)
+		}
+	}
+
+	// Series without a protocol are only about TCP ports
+	if proto == "" || proto == "tcp" {
+		for _, vec := range []*prometheus.GaugeVec{
+			s.UnexpectedServices, s.SYNFallback, s.TLSCertExpiry, s.TLSInfos, s.TLSWeakVersion, s.PortConnectDuration,
+			s.HTTPStatus, s.HTTPResponseTime, s.HTTPInfos, s.HTTPUnexpected,
+		} {
+			vec.DeletePartialMatch(prometheus.Labels{"name": name, "ip": ip})
+		}
+		s.ConnectDuration.DeletePartialMatch(prometheus.Labels{"name": name, "ip": ip})
+	}
+
+	for _, n := range s.Notifiers {
+		n.Forget(name, ip, proto)
+	}
+}
+
+// ForgetPing deletes the ping series of a target, and no longer counts it as
+// down. It must be called from the Updater goroutine, when a PingInfo with
+// Stopped set is received, so it is not overtaken by a late ping result.
+func (s *Server) ForgetPing(name, ip string) {
+	s.Rtt.DeleteLabelValues(name, ip)
+	key := pingKey(name, ip)
+	if s.NotRespondingList[key] {
+		s.NumOfDownTargets.Dec()
+	}
+	delete(s.NotRespondingList, key)
+}
+
+// pingKey is the key of an IP of a target in NotRespondingList, as targets
+// can share an IP.
+func pingKey(name, ip string) string {
+	return name + "/" + ip
+}
+
+// updateTLS replaces the TLS series of a target by the ones of its last scan,
+// so ports that closed or stopped speaking TLS disappear.
+func (s *Server) updateTLS(nm NewMetrics) {
+	for _, vec := range []*prometheus.GaugeVec{s.TLSCertExpiry, s.TLSInfos, s.TLSWeakVersion} {
+		vec.DeletePartialMatch(prometheus.Labels{"name": nm.Name, "ip": nm.IP})
+	}
+
+	for port, info := range nm.TLS {
+		s.TLSInfos.WithLabelValues(nm.Name, nm.IP, port, info.Version, info.Cipher, info.Issuer, info.Subject, strings.Join(info.SANs, ",")).Set(1)
+		if !info.NotAfter.IsZero() {
+			s.TLSCertExpiry.WithLabelValues(nm.Name, nm.IP, port).Set(float64(info.NotAfter.Unix()))
+		}
+
+		weak := 0.0
+		if info.Weak {
+			weak = 1
+			log.Warn().Str("name", nm.Name).Str("ip", nm.IP).Str("port", port).
+				Msgf("%s (%s) port %s accepts weak TLS versions", nm.Name, nm.IP, port)
+		}
+		s.TLSWeakVersion.WithLabelValues(nm.Name, nm.IP, port).Set(weak)
+	}
+}
+
+// updateLatencies observes the connect time of the open TCP ports of a
+// target. When nm.PortLatency is set, the connect time of each open expected
+// port is also exported on its own, and the series of the other ports are
+// deleted.
+func (s *Server) updateLatencies(nm NewMetrics) {
+	s.PortConnectDuration.DeletePartialMatch(prometheus.Labels{"name": nm.Name, "ip": nm.IP})
+	for port, latency := range nm.Latencies {
+		s.ConnectDuration.WithLabelValues(nm.Name, nm.IP).Observe(latency.Seconds())
+		if nm.PortLatency && slices.Contains(nm.Expected, port) {
+			s.PortConnectDuration.WithLabelValues(nm.Name, nm.IP, port).Set(latency.Seconds())
+		}
+	}
+}
+
+// updateHTTP replaces the HTTP series of a target by the ones of its last
+// scan, like updateTLS.
+func (s *Server) updateHTTP(nm NewMetrics) {
+	for _, vec := range []*prometheus.GaugeVec{s.HTTPStatus, s.HTTPResponseTime, s.HTTPInfos, s.HTTPUnexpected} {
+		vec.DeletePartialMatch(prometheus.Labels{"name": nm.Name, "ip": nm.IP})
+	}
+
+	for port, info := range nm.HTTP {
+		if info.Error == "" {
+			s.HTTPStatus.WithLabelValues(nm.Name, nm.IP, port).Set(float64(info.Status))
+			s.HTTPResponseTime.WithLabelValues(nm.Name, nm.IP, port).Set(info.ResponseTime.Seconds())
+			s.HTTPInfos.WithLabelValues(nm.Name, nm.IP, port, info.Scheme, info.Location, info.Server, info.Title).Set(1)
+		}
+
+		s.HTTPUnexpected.WithLabelValues(nm.Name, nm.IP, port).Set(float64(len(info.Unexpected)))
+		if len(info.Unexpected) > 0 {
+			log.Warn().Str("name", nm.Name).Str("ip", nm.IP).Str("port", port).Strs("unexpected", info.Unexpected).
+				Msgf("%s (%s) port %s unexpected HTTP answer: %s", nm.Name, nm.IP, port, strings.Join(info.Unexpected, ", "))
+		}
+	}
+}
+
 // uptime metric
 func (s *Server) uptimeCounter() {
 	for {
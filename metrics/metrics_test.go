@@ -0,0 +1,274 @@
+package metrics
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gaugeValue returns the value of a gauge.
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+// seriesCount returns the number of series of a collector.
+func seriesCount(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	n := 0
+	for range ch {
+		n++
+	}
+	return n
+}
+
+func TestNewMetrics_Unexpected(t *testing.T) {
+	tests := []struct {
+		name       string
+		ports      map[string][]string
+		expected   []string
+		wantOpen   []string
+		wantClosed []string
+	}{
+		{
+			name:     "as expected",
+			ports:    map[string][]string{StateOpen: {"22", "443"}},
+			expected: []string{"22", "443"},
+		},
+		{
+			name:       "unexpected open and closed",
+			ports:      map[string][]string{StateOpen: {"22", "8080"}, StateClosed: {"443"}},
+			expected:   []string{"22", "443"},
+			wantOpen:   []string{"8080"},
+			wantClosed: []string{"443"},
+		},
+		{
+			name:     "open or filtered is not closed",
+			ports:    map[string][]string{StateOpenFiltered: {"53"}},
+			expected: []string{"53"},
+		},
+		{
+			name:       "filtered is closed",
+			ports:      map[string][]string{StateFiltered: {"443"}},
+			expected:   []string{"443"},
+			wantClosed: []string{"443"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nm := NewMetrics{Ports: tt.ports, Expected: tt.expected}
+			open, closed := nm.Unexpected()
+			if !slices.Equal(open, tt.wantOpen) || !slices.Equal(closed, tt.wantClosed) {
+				t.Errorf("Unexpected() = %v, %v, want %v, %v", open, closed, tt.wantOpen, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestNewMetrics_UnexpectedServices(t *testing.T) {
+	nm := NewMetrics{
+		Ports:            map[string][]string{StateOpen: {"8443", "22", "443"}, StateClosed: {"25"}},
+		Services:         map[string]string{"22": "ssh", "443": "http", "8443": "unknown"},
+		ExpectedServices: map[string]string{"22": "ssh", "25": "smtp", "443": "tls", "8443": "tls"},
+	}
+	want := []string{"443:http", "8443:unknown"}
+	if got := nm.UnexpectedServices(); !slices.Equal(got, want) {
+		t.Errorf("UnexpectedServices() = %v, want %v", got, want)
+	}
+}
+
+func TestServer_updateTLS(t *testing.T) {
+	s := Server{
+		TLSCertExpiry:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "expiry"}, []string{"name", "ip", "port"}),
+		TLSInfos:       prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "info"}, []string{"name", "ip", "port", "version", "cipher", "issuer", "subject", "sans"}),
+		TLSWeakVersion: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "weak"}, []string{"name", "ip", "port"}),
+	}
+	expiry := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s.updateTLS(NewMetrics{Name: "app1", IP: "10.0.0.1", TLS: map[string]TLSInfo{
+		"443":  {Version: "TLS 1.3", Cipher: "TLS_AES_128_GCM_SHA256", Issuer: "CN=ca", Subject: "CN=app1", SANs: []string{"app1.example.com", "10.0.0.1"}, NotAfter: expiry},
+		"8443": {Version: "TLS 1.2", Weak: true},
+	}})
+	if got := gaugeValue(t, s.TLSCertExpiry.WithLabelValues("app1", "10.0.0.1", "443")); got != float64(expiry.Unix()) {
+		t.Errorf("expiry = %v, want %v", got, expiry.Unix())
+	}
+	if got := gaugeValue(t, s.TLSInfos.WithLabelValues("app1", "10.0.0.1", "443", "TLS 1.3", "TLS_AES_128_GCM_SHA256", "CN=ca", "CN=app1", "app1.example.com,10.0.0.1")); got != 1 {
+		t.Errorf("info = %v, want 1", got)
+	}
+	if got := gaugeValue(t, s.TLSWeakVersion.WithLabelValues("app1", "10.0.0.1", "8443")); got != 1 {
+		t.Errorf("weak = %v, want 1", got)
+	}
+
+	// Ports that are no longer TLS are forgotten
+	s.updateTLS(NewMetrics{Name: "app1", IP: "10.0.0.1", TLS: map[string]TLSInfo{"443": {Version: "TLS 1.3", NotAfter: expiry}}})
+	if got := seriesCount(s.TLSWeakVersion); got != 1 {
+		t.Errorf("got %d weak version series, want 1", got)
+	}
+	if got := seriesCount(s.TLSInfos); got != 1 {
+		t.Errorf("got %d info series, want 1", got)
+	}
+}
+
+func TestServer_updateLatencies(t *testing.T) {
+	s := Server{
+		ConnectDuration:     prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "connect"}, []string{"name", "ip"}),
+		PortConnectDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "port_connect"}, []string{"name", "ip", "port"}),
+	}
+	nm := NewMetrics{
+		Name:        "app1",
+		IP:          "10.0.0.1",
+		Expected:    []string{"22", "443"},
+		Latencies:   map[string]time.Duration{"22": 2 * time.Millisecond, "8080": 4 * time.Millisecond},
+		PortLatency: true,
+	}
+
+	s.updateLatencies(nm)
+	var m dto.Metric
+	if err := s.ConnectDuration.WithLabelValues("app1", "10.0.0.1").(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetHistogram().GetSampleCount(); got != 2 {
+		t.Errorf("got %d connect samples, want 2", got)
+	}
+	if got := m.GetHistogram().GetSampleSum(); got != 0.006 {
+		t.Errorf("connect samples sum = %v, want 0.006", got)
+	}
+	// Only open expected ports get their own series
+	if got := gaugeValue(t, s.PortConnectDuration.WithLabelValues("app1", "10.0.0.1", "22")); got != 0.002 {
+		t.Errorf("port 22 connect time = %v, want 0.002", got)
+	}
+	if got := seriesCount(s.PortConnectDuration); got != 1 {
+		t.Errorf("got %d port connect series, want 1", got)
+	}
+
+	nm.PortLatency = false
+	s.updateLatencies(nm)
+	if got := seriesCount(s.PortConnectDuration); got != 0 {
+		t.Errorf("got %d port connect series once disabled, want 0", got)
+	}
+}
+
+func TestServer_updateHTTP(t *testing.T) {
+	s := Server{
+		HTTPStatus:       prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "status"}, []string{"name", "ip", "port"}),
+		HTTPResponseTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "seconds"}, []string{"name", "ip", "port"}),
+		HTTPInfos:        prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "info"}, []string{"name", "ip", "port", "scheme", "location", "server", "title"}),
+		HTTPUnexpected:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "unexpected"}, []string{"name", "ip", "port"}),
+	}
+
+	s.updateHTTP(NewMetrics{Name: "app1", IP: "10.0.0.1", HTTP: map[string]HTTPInfo{
+		"80":  {Scheme: "http", Status: 200, Server: "nginx", ResponseTime: 250 * time.Millisecond, Unexpected: []string{"status 200, want 301"}},
+		"443": {Scheme: "https", Status: 200, Title: "Home"},
+		"22":  {Error: "not a web port, service is ssh", Unexpected: []string{"no HTTP answer"}},
+	}})
+	if got := gaugeValue(t, s.HTTPStatus.WithLabelValues("app1", "10.0.0.1", "80")); got != 200 {
+		t.Errorf("status = %v, want 200", got)
+	}
+	if got := gaugeValue(t, s.HTTPResponseTime.WithLabelValues("app1", "10.0.0.1", "80")); got != 0.25 {
+		t.Errorf("response time = %v, want 0.25", got)
+	}
+	if got := gaugeValue(t, s.HTTPInfos.WithLabelValues("app1", "10.0.0.1", "443", "https", "", "", "Home")); got != 1 {
+		t.Errorf("info = %v, want 1", got)
+	}
+	if got := gaugeValue(t, s.HTTPUnexpected.WithLabelValues("app1", "10.0.0.1", "22")); got != 1 {
+		t.Errorf("unexpected = %v, want 1", got)
+	}
+	// Ports without answer only get the unexpected series
+	if got := seriesCount(s.HTTPStatus); got != 2 {
+		t.Errorf("got %d status series, want 2", got)
+	}
+
+	s.updateHTTP(NewMetrics{Name: "app1", IP: "10.0.0.1", HTTP: map[string]HTTPInfo{"443": {Scheme: "https", Status: 200}}})
+	if got := seriesCount(s.HTTPUnexpected); got != 1 {
+		t.Errorf("got %d unexpected series, want 1", got)
+	}
+	if got := seriesCount(s.HTTPInfos); got != 1 {
+		t.Errorf("got %d info series, want 1", got)
+	}
+}
+
+func TestServer_ForgetPing(t *testing.T) {
+	s := Server{
+		Rtt:               prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "rtt"}, []string{"name", "ip"}),
+		NumOfDownTargets:  prometheus.NewGauge(prometheus.GaugeOpts{Name: "down"}),
+		NotRespondingList: map[string]bool{"app1/10.0.0.1": true, "app2/10.0.0.1": true, "app3/10.0.0.2": false},
+	}
+	s.Rtt.WithLabelValues("app1", "10.0.0.1").Set(0)
+	s.Rtt.WithLabelValues("app2", "10.0.0.1").Set(0)
+	s.Rtt.WithLabelValues("app3", "10.0.0.2").Set(0.001)
+	s.NumOfDownTargets.Set(2)
+
+	// app2 shares the IP of app1, and is still down
+	s.ForgetPing("app1", "10.0.0.1")
+	if got := seriesCount(s.Rtt); got != 2 {
+		t.Errorf("got %d RTT series, want 2", got)
+	}
+	if got := gaugeValue(t, s.NumOfDownTargets); got != 1 {
+		t.Errorf("got %v down targets, want 1", got)
+	}
+	if _, ok := s.NotRespondingList["app1/10.0.0.1"]; ok {
+		t.Errorf("app1 (10.0.0.1) is still in the not responding list")
+	}
+
+	s.ForgetPing("app3", "10.0.0.2")
+	if got := gaugeValue(t, s.NumOfDownTargets); got != 1 {
+		t.Errorf("got %v down targets after forgetting a responding target, want 1", got)
+	}
+}
+
+func TestServer_Forget(t *testing.T) {
+	tests := []struct {
+		name        string
+		proto       string
+		wantTCP     bool
+		wantUDP     bool
+		wantTLS     int
+		wantPortKey []string
+	}{
+		{name: "target", proto: ""},
+		{name: "tcp", proto: "tcp", wantUDP: true, wantPortKey: []string{"app1/10.0.0.1/udp"}},
+		{name: "udp", proto: "udp", wantTCP: true, wantTLS: 1, wantPortKey: []string{"app1/10.0.0.1/tcp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer()
+			for _, proto := range []string{"tcp", "udp"} {
+				s.OpenPorts.WithLabelValues("app1", "10.0.0.1", proto).Set(1)
+				s.portStateSeries["app1/10.0.0.1/"+proto] = map[string]string{}
+			}
+			s.OpenPorts.WithLabelValues("app2", "10.0.0.1", "udp").Set(1)
+			s.TLSCertExpiry.WithLabelValues("app1", "10.0.0.1", "443").Set(0)
+
+			s.Forget("app1", "10.0.0.1", tt.proto)
+
+			// Deleting a series reports whether it was kept
+			if got := s.OpenPorts.DeleteLabelValues("app1", "10.0.0.1", "tcp"); got != tt.wantTCP {
+				t.Errorf("TCP series kept = %v, want %v", got, tt.wantTCP)
+			}
+			if got := s.OpenPorts.DeleteLabelValues("app1", "10.0.0.1", "udp"); got != tt.wantUDP {
+				t.Errorf("UDP series kept = %v, want %v", got, tt.wantUDP)
+			}
+			if got := seriesCount(s.OpenPorts); got != 1 {
+				t.Errorf("got %d series of other targets, want 1", got)
+			}
+			if got := seriesCount(s.TLSCertExpiry); got != tt.wantTLS {
+				t.Errorf("got %d TLS series, want %d", got, tt.wantTLS)
+			}
+			if got := slices.Sorted(maps.Keys(s.portStateSeries)); !slices.Equal(got, tt.wantPortKey) {
+				t.Errorf("got per-port series of %v, want %v", got, tt.wantPortKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/devops-works/scan-exporter/common"
+	"github.com/rs/zerolog/log"
+)
+
+// updatePortStates sets the per-port series of a target: 1 for open ports, 0
+// for expected ports that are not open. Series of ports that are neither open
+// nor expected anymore are deleted. Nothing is done if nm.PortStateLimit is 0.
+func (s *Server) updatePortStates(nm NewMetrics) {
+	key := nm.Name + "/" + nm.IP + "/" + nm.Proto
+	previous := s.portStateSeries[key]
+
+	current := map[string]string{}
+	if nm.PortStateLimit > 0 {
+		ports, dropped := selectPorts(nm.Ports[StateOpen], nm.Expected, nm.PortStateLimit)
+		for _, port := range ports {
+			exp := strconv.FormatBool(common.StringInSlice(port, nm.Expected))
+			current[port] = exp
+
+			value := 0.
+			if common.StringInSlice(port, nm.Ports[StateOpen]) {
+				value = 1
+			}
+			s.PortState.WithLabelValues(nm.Name, nm.IP, port, nm.Proto, exp).Set(value)
+		}
+
+		s.PortStateDropped.WithLabelValues(nm.Name, nm.IP, nm.Proto).Set(float64(dropped))
+		if dropped > 0 {
+			log.Warn().Str("name", nm.Name).Str("ip", nm.IP).Str("proto", nm.Proto).Int("dropped", dropped).
+				Msgf("%s (%s) has too many %s ports for per-port metrics, %d are not exported", nm.Name, nm.IP, nm.Proto, dropped)
+		}
+	} else {
+		s.PortStateDropped.DeleteLabelValues(nm.Name, nm.IP, nm.Proto)
+	}
+
+	// Delete stale series, including the ones whose expected label changed
+	for port, exp := range previous {
+		if current[port] != exp {
+			s.PortState.DeleteLabelValues(nm.Name, nm.IP, port, nm.Proto, exp)
+		}
+	}
+
+	if len(current) == 0 {
+		delete(s.portStateSeries, key)
+		return
+	}
+	s.portStateSeries[key] = current
+}
+
+// selectPorts returns the ports that get a per-port series, within limit.
+// Expected ports come first, then open ones in ascending order. The number of
+// ports left out is returned too.
+func selectPorts(open, expected []string, limit int) ([]string, int) {
+	byNumber := func(a, b string) int {
+		na, _ := strconv.Atoi(a)
+		nb, _ := strconv.Atoi(b)
+		return na - nb
+	}
+
+	ports := append([]string{}, expected...)
+	slices.SortFunc(ports, byNumber)
+
+	unexpected := []string{}
+	for _, port := range open {
+		if !common.StringInSlice(port, expected) {
+			unexpected = append(unexpected, port)
+		}
+	}
+	slices.SortFunc(unexpected, byNumber)
+	ports = append(ports, unexpected...)
+
+	if len(ports) <= limit {
+		return ports, 0
+	}
+	return ports[:limit], len(ports) - limit
+}
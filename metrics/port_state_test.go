@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_selectPorts(t *testing.T) {
+	tests := []struct {
+		name        string
+		open        []string
+		expected    []string
+		limit       int
+		want        []string
+		wantDropped int
+	}{
+		{name: "nothing", limit: 10, want: []string{}},
+		{name: "expected first", open: []string{"8080", "22", "443"}, expected: []string{"443", "22"}, limit: 10, want: []string{"22", "443", "8080"}},
+		{name: "numeric order", open: []string{"1000", "200", "30"}, limit: 10, want: []string{"30", "200", "1000"}},
+		{name: "expected closed", open: []string{"80"}, expected: []string{"22"}, limit: 10, want: []string{"22", "80"}},
+		{name: "limit", open: []string{"1", "2", "3", "4"}, expected: []string{"4"}, limit: 2, want: []string{"4", "1"}, wantDropped: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := selectPorts(tt.open, tt.expected, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectPorts() = %v, want %v", got, tt.want)
+			}
+			if dropped != tt.wantDropped {
+				t.Errorf("selectPorts() dropped = %v, want %v", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultResendInterval is the interval between two pushes of the firing
+	// alerts.
+	defaultResendInterval = time.Minute
+	// alertmanagerTimeout is the timeout of a single push to Alertmanager.
+	alertmanagerTimeout = 10 * time.Second
+)
+
+// Alert names pushed to Alertmanager.
+const (
+	alertUnexpectedOpen   = "ScanExporterUnexpectedOpenPorts"
+	alertUnexpectedClosed = "ScanExporterUnexpectedClosedPorts"
+)
+
+// postableAlert is an alert, as expected by the Alertmanager v2 API.
+type postableAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// Alertmanager pushes alerts to Alertmanager when a target has unexpected open
+// or closed ports, and resolves them when the condition clears. Firing alerts
+// are pushed again at each resend interval, with an end time far enough to
+// survive a missed push. So, if scan-exporter stops, they resolve by
+// themselves.
+type Alertmanager struct {
+	mu     sync.Mutex
+	urls   []string
+	labels map[string]string
+	resend time.Duration
+	// alerts holds the firing alerts, by alert name, target, IP and protocol
+	alerts map[string]*postableAlert
+
+	client *http.Client
+	queue  chan batch
+}
+
+// batch is a push of alerts to a list of Alertmanager URLs.
+type batch struct {
+	urls   []string
+	alerts []postableAlert
+}
+
+// NewAlertmanager creates the Alertmanager notifier and starts its sender.
+func NewAlertmanager(c config.Notifications) (*Alertmanager, error) {
+	a := Alertmanager{
+		alerts: make(map[string]*postableAlert),
+		client: &http.Client{Timeout: alertmanagerTimeout},
+		queue:  make(chan batch, 100),
+	}
+	if err := a.Configure(c); err != nil {
+		return nil, err
+	}
+
+	go a.run()
+
+	return &a, nil
+}
+
+// ValidateAlertmanager checks the Alertmanager settings, without changing
+// anything.
+func ValidateAlertmanager(c config.Notifications) error {
+	_, _, err := alertmanagerSettings(c)
+	return err
+}
+
+// alertmanagerSettings returns the URLs alerts are pushed to and the resend
+// interval of the configuration.
+func alertmanagerSettings(c config.Notifications) ([]string, time.Duration, error) {
+	resend := defaultResendInterval
+	if c.Alertmanager.ResendInterval != "" {
+		var err error
+		resend, err = time.ParseDuration(c.Alertmanager.ResendInterval)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid Alertmanager resend interval: %w", err)
+		}
+		if resend <= 0 {
+			return nil, 0, fmt.Errorf("Alertmanager resend interval must be positive")
+		}
+	}
+
+	urls := []string{}
+	for _, u := range c.Alertmanager.URLs {
+		urls = append(urls, strings.TrimSuffix(u, "/")+"/api/v2/alerts")
+	}
+	return urls, resend, nil
+}
+
+// Configure replaces the Alertmanager settings. Nothing is changed if the
+// configuration is invalid. The firing alerts are resolved on the
+// Alertmanagers that are removed, and pushed to the ones that are added. When
+// the labels change, they are resolved and pushed again with the new labels
+// everywhere. When no URL is given, alerts are not pushed anymore.
+func (a *Alertmanager) Configure(c config.Notifications) error {
+	urls, resend, err := alertmanagerSettings(c)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	removed := slices.DeleteFunc(slices.Clone(a.urls), func(u string) bool { return slices.Contains(urls, u) })
+	added := slices.DeleteFunc(slices.Clone(urls), func(u string) bool { return slices.Contains(a.urls, u) })
+	if !maps.Equal(a.labels, c.Alertmanager.Labels) {
+		removed, added = a.urls, urls
+	}
+
+	now := time.Now()
+	a.enqueue(removed, a.firing(now))
+	a.urls = urls
+	a.labels = c.Alertmanager.Labels
+	a.resend = resend
+	if len(urls) == 0 {
+		clear(a.alerts)
+	}
+	for _, alert := range a.alerts {
+		alert.Labels = a.relabel(alert.Labels)
+	}
+	a.enqueue(added, a.firing(a.endsAt(now)))
+
+	return nil
+}
+
+// Notify fires or resolves the alerts of the scanned target.
+func (a *Alertmanager) Notify(e Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.urls) == 0 {
+		return
+	}
+
+	now := time.Now()
+	push := []postableAlert{}
+	for alertname, ports := range map[string][]string{
+		alertUnexpectedOpen:   e.UnexpectedOpen,
+		alertUnexpectedClosed: e.UnexpectedClosed,
+	} {
+		key := strings.Join([]string{alertname, e.Name, e.IP, e.Proto}, "/")
+		alert, firing := a.alerts[key]
+
+		switch {
+		case len(ports) > 0:
+			if !firing {
+				alert = &postableAlert{
+					Labels:   a.alertLabels(alertname, e),
+					StartsAt: now,
+				}
+				a.alerts[key] = alert
+			}
+			alert.Annotations = alertAnnotations(alertname, e, ports)
+			alert.EndsAt = a.endsAt(now)
+			push = append(push, *alert)
+		case firing:
+			// The condition cleared, resolve the alert
+			alert.EndsAt = now
+			push = append(push, *alert)
+			delete(a.alerts, key)
+		}
+	}
+
+	a.enqueue(a.urls, push)
+}
+
+// Forget resolves all the alerts of a target's IP, or only the ones of proto
+// when it is set.
+func (a *Alertmanager) Forget(name, ip, proto string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	push := []postableAlert{}
+	for key, alert := range a.alerts {
+		if alert.Labels["name"] == name && alert.Labels["ip"] == ip && (proto == "" || alert.Labels["proto"] == proto) {
+			alert.EndsAt = now
+			push = append(push, *alert)
+			delete(a.alerts, key)
+		}
+	}
+
+	a.enqueue(a.urls, push)
+}
+
+// firing returns a copy of the firing alerts, ending at endsAt. It must be
+// called with the lock held.
+func (a *Alertmanager) firing(endsAt time.Time) []postableAlert {
+	alerts := []postableAlert{}
+	for _, alert := range a.alerts {
+		copied := *alert
+		copied.EndsAt = endsAt
+		alerts = append(alerts, copied)
+	}
+	return alerts
+}
+
+// alertLabels returns the labels of an alert. Configured labels can not
+// override the ones identifying the alert.
+func (a *Alertmanager) alertLabels(alertname string, e Event) map[string]string {
+	labels := maps.Clone(a.labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels["alertname"] = alertname
+	labels["name"] = e.Name
+	labels["ip"] = e.IP
+	labels["proto"] = e.Proto
+
+	return labels
+}
+
+// relabel returns the labels of a firing alert with the configured labels, so
+// they follow configuration changes. It must be called with the lock held.
+func (a *Alertmanager) relabel(labels map[string]string) map[string]string {
+	return a.alertLabels(labels["alertname"], Event{Name: labels["name"], IP: labels["ip"], Proto: labels["proto"]})
+}
+
+// alertAnnotations describes the ports that raised an alert.
+func alertAnnotations(alertname string, e Event, ports []string) map[string]string {
+	what := "open"
+	if alertname == alertUnexpectedClosed {
+		what = "closed"
+	}
+
+	return map[string]string{
+		"summary":     fmt.Sprintf("%s (%s) has unexpected %s %s ports", e.Name, e.IP, what, e.Proto),
+		"description": fmt.Sprintf("%s (%s) has %d unexpected %s %s ports: %s", e.Name, e.IP, len(ports), what, e.Proto, strings.Join(ports, ", ")),
+		"ports":       strings.Join(ports, ","),
+	}
+}
+
+// endsAt returns the end time of a firing alert pushed at now. It covers two
+// missed pushes.
+func (a *Alertmanager) endsAt(now time.Time) time.Time {
+	return now.Add(3 * a.resend)
+}
+
+// enqueue queues alerts to be pushed to urls. They are dropped if the queue is
+// full, firing ones will be pushed again at the next resend. It must be called
+// with the lock held.
+func (a *Alertmanager) enqueue(urls []string, alerts []postableAlert) {
+	if len(urls) == 0 || len(alerts) == 0 {
+		return
+	}
+
+	select {
+	case a.queue <- batch{urls: urls, alerts: alerts}:
+	default:
+		log.Error().Msgf("Alertmanager queue full, dropping %d alerts", len(alerts))
+	}
+}
+
+// run pushes the queued alerts, and all the firing ones at each resend
+// interval.
+func (a *Alertmanager) run() {
+	a.mu.Lock()
+	resend := a.resend
+	a.mu.Unlock()
+
+	ticker := time.NewTicker(resend)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case b := <-a.queue:
+			a.push(b.urls, b.alerts)
+		case <-ticker.C:
+			a.mu.Lock()
+			now := time.Now()
+			alerts := []postableAlert{}
+			for _, alert := range a.alerts {
+				alert.EndsAt = a.endsAt(now)
+				alerts = append(alerts, *alert)
+			}
+			urls := a.urls
+			// Follow resend interval changes
+			if a.resend != resend {
+				resend = a.resend
+				ticker.Reset(resend)
+			}
+			a.mu.Unlock()
+
+			if len(alerts) > 0 {
+				a.push(urls, alerts)
+			}
+		}
+	}
+}
+
+// push sends alerts to every Alertmanager of urls, as recommended for highly
+// available setups.
+func (a *Alertmanager) push(urls []string, alerts []postableAlert) {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot encode alerts")
+		return
+	}
+
+	for _, u := range urls {
+		resp, err := a.client.Post(u, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Error().Err(err).Msgf("cannot push alerts to %s", u)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			log.Error().Msgf("cannot push alerts to %s: unexpected status %s", u, resp.Status)
+			continue
+		}
+		log.Debug().Msgf("pushed %d alerts to %s", len(alerts), u)
+	}
+}
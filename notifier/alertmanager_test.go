@@ -0,0 +1,211 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devops-works/scan-exporter/config"
+)
+
+func TestAlertmanager(t *testing.T) {
+	var mu sync.Mutex
+	pushes := [][]postableAlert{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			t.Errorf("got request to %s, want /api/v2/alerts", r.URL.Path)
+		}
+
+		alerts := []postableAlert{}
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			t.Errorf("cannot decode alerts: %v", err)
+		}
+		mu.Lock()
+		pushes = append(pushes, alerts)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	a, err := NewAlertmanager(config.Notifications{
+		Alertmanager: config.Alertmanager{
+			URLs:   []string{srv.URL + "/"},
+			Labels: map[string]string{"team": "ops", "name": "overridden"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewAlertmanager() error = %v", err)
+	}
+
+	wait := func(n int) []postableAlert {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			mu.Lock()
+			if len(pushes) >= n {
+				defer mu.Unlock()
+				return pushes[n-1]
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("push %d not received", n)
+		return nil
+	}
+
+	// Fires the unexpected open ports alert
+	a.Notify(Event{Name: "app1", IP: "10.0.0.1", Proto: "tcp", UnexpectedOpen: []string{"22"}})
+	alerts := wait(1)
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(alerts))
+	}
+	got := alerts[0]
+	wantLabels := map[string]string{"alertname": alertUnexpectedOpen, "name": "app1", "ip": "10.0.0.1", "proto": "tcp", "team": "ops"}
+	for k, v := range wantLabels {
+		if got.Labels[k] != v {
+			t.Errorf("label %s = %q, want %q", k, got.Labels[k], v)
+		}
+	}
+	if got.Annotations["ports"] != "22" {
+		t.Errorf("ports annotation = %q, want 22", got.Annotations["ports"])
+	}
+	if !got.EndsAt.After(time.Now()) {
+		t.Errorf("firing alert ends at %s, in the past", got.EndsAt)
+	}
+	startsAt := got.StartsAt
+
+	// Still firing, with the same start time
+	a.Notify(Event{Name: "app1", IP: "10.0.0.1", Proto: "tcp", UnexpectedOpen: []string{"22", "23"}})
+	alerts = wait(2)
+	if len(alerts) != 1 || !alerts[0].StartsAt.Equal(startsAt) || alerts[0].Annotations["ports"] != "22,23" {
+		t.Errorf("got %+v, want the same alert with ports 22,23", alerts)
+	}
+
+	// Resolved
+	a.Notify(Event{Name: "app1", IP: "10.0.0.1", Proto: "tcp"})
+	alerts = wait(3)
+	if len(alerts) != 1 || alerts[0].EndsAt.After(time.Now()) {
+		t.Errorf("got %+v, want a resolved alert", alerts)
+	}
+
+	// Resolved when the protocol of the target is forgotten, and only then
+	a.Notify(Event{Name: "app1", IP: "10.0.0.1", Proto: "udp", UnexpectedClosed: []string{"53"}})
+	wait(4)
+	a.Forget("app1", "10.0.0.1", "tcp")
+	a.Forget("app1", "10.0.0.1", "udp")
+	alerts = wait(5)
+	if len(alerts) != 1 || alerts[0].Labels["alertname"] != alertUnexpectedClosed || alerts[0].EndsAt.After(time.Now()) {
+		t.Errorf("got %+v, want a resolved unexpected closed ports alert", alerts)
+	}
+
+	// Nothing to push
+	a.Notify(Event{Name: "app2", IP: "10.0.0.2", Proto: "tcp"})
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pushes) != 5 {
+		t.Errorf("got %d pushes, want 5", len(pushes))
+	}
+}
+
+func TestAlertmanager_Configure(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    config.Alertmanager
+		wantErr bool
+	}{
+		{name: "empty", conf: config.Alertmanager{}},
+		{name: "valid", conf: config.Alertmanager{URLs: []string{"http://localhost:9093"}, ResendInterval: "30s"}},
+		{name: "invalid resend interval", conf: config.Alertmanager{ResendInterval: "1d"}, wantErr: true},
+		{name: "negative resend interval", conf: config.Alertmanager{ResendInterval: "-1m"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateAlertmanager(config.Notifications{Alertmanager: tt.conf}); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAlertmanager() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			a := Alertmanager{alerts: make(map[string]*postableAlert)}
+			if err := a.Configure(config.Notifications{Alertmanager: tt.conf}); (err != nil) != tt.wantErr {
+				t.Errorf("Configure() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAlertmanager_Configure_reload(t *testing.T) {
+	// alertmanager records the alerts pushed to it
+	type alertmanager struct {
+		mu     sync.Mutex
+		alerts []postableAlert
+		url    string
+	}
+	start := func() *alertmanager {
+		am := &alertmanager{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			alerts := []postableAlert{}
+			if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+				t.Errorf("cannot decode alerts: %v", err)
+			}
+			am.mu.Lock()
+			am.alerts = append(am.alerts, alerts...)
+			am.mu.Unlock()
+		}))
+		t.Cleanup(srv.Close)
+		am.url = srv.URL
+		return am
+	}
+	// wait returns the nth alert pushed to am
+	wait := func(am *alertmanager, n int) postableAlert {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			am.mu.Lock()
+			if len(am.alerts) >= n {
+				defer am.mu.Unlock()
+				return am.alerts[n-1]
+			}
+			am.mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("alert %d not pushed to %s", n, am.url)
+		return postableAlert{}
+	}
+
+	old, added := start(), start()
+	a, err := NewAlertmanager(config.Notifications{Alertmanager: config.Alertmanager{URLs: []string{old.url}}})
+	if err != nil {
+		t.Fatalf("NewAlertmanager() error = %v", err)
+	}
+	a.Notify(Event{Name: "app1", IP: "10.0.0.1", Proto: "tcp", UnexpectedOpen: []string{"22"}})
+	wait(old, 1)
+
+	// The alert is resolved on the removed Alertmanager, and fired on the
+	// added one
+	if err := a.Configure(config.Notifications{Alertmanager: config.Alertmanager{URLs: []string{added.url}}}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	if got := wait(old, 2); got.EndsAt.After(time.Now()) {
+		t.Errorf("alert pushed to the removed Alertmanager ends at %s, want it resolved", got.EndsAt)
+	}
+	if got := wait(added, 1); !got.EndsAt.After(time.Now()) || got.Labels["name"] != "app1" {
+		t.Errorf("alert pushed to the added Alertmanager = %+v, want it firing", got)
+	}
+
+	// New labels resolve the alert and fire it again with them
+	if err := a.Configure(config.Notifications{Alertmanager: config.Alertmanager{URLs: []string{added.url}, Labels: map[string]string{"team": "ops"}}}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	if got := wait(added, 2); got.EndsAt.After(time.Now()) || got.Labels["team"] != "" {
+		t.Errorf("alert with the old labels = %+v, want it resolved", got)
+	}
+	if got := wait(added, 3); !got.EndsAt.After(time.Now()) || got.Labels["team"] != "ops" || got.Labels["alertname"] != alertUnexpectedOpen {
+		t.Errorf("alert with the new labels = %+v, want it firing", got)
+	}
+
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	if len(old.alerts) != 2 {
+		t.Errorf("got %d alerts pushed to the removed Alertmanager, want 2", len(old.alerts))
+	}
+}
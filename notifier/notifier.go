@@ -0,0 +1,31 @@
+package notifier
+
+import "time"
+
+// Event is the result of a scan of a target, compared to the previous scan and
+// to the expected ports.
+type Event struct {
+	Time             time.Time `json:"time"`
+	Name             string    `json:"name"`
+	IP               string    `json:"ip"`
+	Proto            string    `json:"proto"`
+	Open             []string  `json:"open"`
+	Previous         []string  `json:"previous"`
+	Opened           []string  `json:"opened"`
+	Closed           []string  `json:"closed"`
+	UnexpectedOpen   []string  `json:"unexpected_open"`
+	UnexpectedClosed []string  `json:"unexpected_closed"`
+}
+
+// Changed reports whether the open ports differ from the previous scan.
+func (e Event) Changed() bool {
+	return len(e.Opened)+len(e.Closed) > 0
+}
+
+// Notifier is sent the result of every scan, and decides what to do with it.
+// Forget is called when an IP of a target is not scanned anymore, or only not
+// using proto when it is set. Both must not block.
+type Notifier interface {
+	Notify(e Event)
+	Forget(name, ip, proto string)
+}
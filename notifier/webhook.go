@@ -0,0 +1,285 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultDedupWindow is the time during which an identical change is not
+	// sent again.
+	defaultDedupWindow = time.Hour
+	// defaultWebhookTimeout is the timeout of a single webhook request.
+	defaultWebhookTimeout = 10 * time.Second
+	// defaultMaxRetries is the number of retries of a failed webhook request.
+	defaultMaxRetries = 3
+)
+
+// templateFuncs are the functions available in webhook templates, on top of
+// the builtin ones.
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Webhooks POSTs the changes in scan results to a list of URLs. Identical
+// changes are only sent once during the deduplication window, and failed
+// requests are retried with an exponential backoff.
+type Webhooks struct {
+	mu    sync.Mutex
+	hooks []*webhook
+	dedup time.Duration
+	sent  map[changeKey]time.Time
+
+	// backoff is the delay before the first retry. It doubles at each retry.
+	backoff time.Duration
+	queue   chan Event
+}
+
+// changeKey identifies a change for deduplication. Ports are joined with
+// commas.
+type changeKey struct {
+	name, ip, proto                  string
+	opened, closed                   string
+	unexpectedOpen, unexpectedClosed string
+}
+
+type webhook struct {
+	url     string
+	headers map[string]string
+	tmpl    *template.Template
+	retries int
+	client  *http.Client
+}
+
+// NewWebhooks creates the webhooks notifier and starts its sender.
+func NewWebhooks(c config.Notifications) (*Webhooks, error) {
+	w := Webhooks{
+		sent:    make(map[changeKey]time.Time),
+		backoff: time.Second,
+		queue:   make(chan Event, 100),
+	}
+	if err := w.Configure(c); err != nil {
+		return nil, err
+	}
+
+	go w.run()
+
+	return &w, nil
+}
+
+// Configure replaces the webhooks. Nothing is changed if the configuration is
+// invalid.
+func (w *Webhooks) Configure(c config.Notifications) error {
+	hooks, dedup, err := webhooksSettings(c)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hooks = hooks
+	w.dedup = dedup
+
+	return nil
+}
+
+// ValidateWebhooks checks the webhooks settings, without changing anything.
+func ValidateWebhooks(c config.Notifications) error {
+	_, _, err := webhooksSettings(c)
+	return err
+}
+
+// webhooksSettings returns the webhooks and the deduplication window of the
+// configuration.
+func webhooksSettings(c config.Notifications) ([]*webhook, time.Duration, error) {
+	dedup := defaultDedupWindow
+	if c.DedupWindow != "" {
+		var err error
+		dedup, err = time.ParseDuration(c.DedupWindow)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid dedup window: %w", err)
+		}
+	}
+
+	hooks := []*webhook{}
+	for _, wc := range c.Webhooks {
+		if wc.URL == "" {
+			return nil, 0, fmt.Errorf("webhook without URL")
+		}
+
+		h := webhook{
+			url:     wc.URL,
+			headers: wc.Headers,
+			retries: wc.MaxRetries,
+			client:  &http.Client{Timeout: defaultWebhookTimeout},
+		}
+		if h.retries == 0 {
+			h.retries = defaultMaxRetries
+		}
+		if wc.Timeout != "" {
+			timeout, err := time.ParseDuration(wc.Timeout)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid timeout for webhook %s: %w", wc.URL, err)
+			}
+			h.client.Timeout = timeout
+		}
+		if wc.Template != "" {
+			tmpl, err := template.New(wc.URL).Funcs(templateFuncs).Parse(wc.Template)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid template for webhook %s: %w", wc.URL, err)
+			}
+			h.tmpl = tmpl
+		}
+
+		hooks = append(hooks, &h)
+	}
+	return hooks, dedup, nil
+}
+
+// Notify queues the event if the open ports changed. Events are dropped if the
+// queue is full.
+func (w *Webhooks) Notify(e Event) {
+	if !e.Changed() {
+		return
+	}
+
+	select {
+	case w.queue <- e:
+	default:
+		log.Error().Str("name", e.Name).Str("ip", e.IP).Msgf("webhook queue full, dropping change of %s (%s)", e.Name, e.IP)
+	}
+}
+
+// Forget does nothing, as webhooks only report changes.
+func (w *Webhooks) Forget(name, ip, proto string) {}
+
+// run sends the queued events to all the webhooks.
+func (w *Webhooks) run() {
+	for e := range w.queue {
+		w.mu.Lock()
+		hooks := w.hooks
+		duplicate := w.isDuplicate(e)
+		w.mu.Unlock()
+
+		if duplicate {
+			log.Debug().Str("name", e.Name).Str("ip", e.IP).Msgf("change of %s (%s) already sent", e.Name, e.IP)
+			continue
+		}
+
+		for _, h := range hooks {
+			go func(h *webhook) {
+				if err := h.deliver(e, w.backoff); err != nil {
+					log.Error().Err(err).Str("name", e.Name).Str("ip", e.IP).Msgf("cannot send change of %s (%s) to %s", e.Name, e.IP, h.url)
+				}
+			}(h)
+		}
+	}
+}
+
+// isDuplicate reports whether the same change has been sent during the
+// deduplication window, and records it otherwise. It must be called with the
+// lock held.
+func (w *Webhooks) isDuplicate(e Event) bool {
+	now := time.Now()
+
+	// Forget expired changes
+	for key, sent := range w.sent {
+		if now.Sub(sent) > w.dedup {
+			delete(w.sent, key)
+		}
+	}
+
+	key := changeKey{
+		name:             e.Name,
+		ip:               e.IP,
+		proto:            e.Proto,
+		opened:           strings.Join(e.Opened, ","),
+		closed:           strings.Join(e.Closed, ","),
+		unexpectedOpen:   strings.Join(e.UnexpectedOpen, ","),
+		unexpectedClosed: strings.Join(e.UnexpectedClosed, ","),
+	}
+	if _, ok := w.sent[key]; ok {
+		return true
+	}
+	w.sent[key] = now
+
+	return false
+}
+
+// deliver sends the event, retrying with an exponential backoff on network
+// errors, server errors and rate limiting.
+func (h *webhook) deliver(e Event, backoff time.Duration) error {
+	body, err := h.body(e)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		retry, err := h.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= h.retries {
+			return err
+		}
+
+		log.Debug().Err(err).Msgf("webhook %s failed, retrying in %s", h.url, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// body renders the webhook template, or encodes the event in JSON if there is
+// no template.
+func (h *webhook) body(e Event) ([]byte, error) {
+	if h.tmpl == nil {
+		return json.Marshal(e)
+	}
+
+	var buf bytes.Buffer
+	if err := h.tmpl.Execute(&buf, e); err != nil {
+		return nil, fmt.Errorf("cannot render template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends a single request. It returns whether a failed request can be
+// retried.
+func (h *webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
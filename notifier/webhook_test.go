@@ -0,0 +1,111 @@
+package notifier
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devops-works/scan-exporter/config"
+)
+
+func TestWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	bodies := []string{}
+	calls := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		// Fail the first request to trigger a retry
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+	}))
+	defer srv.Close()
+
+	w, err := NewWebhooks(config.Notifications{
+		Webhooks: []config.Webhook{{
+			URL:      srv.URL,
+			Template: `{{ .Name }} opened {{ join .Opened "," }} {{ json .Closed }}`,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("NewWebhooks() error = %v", err)
+	}
+	w.backoff = time.Millisecond
+
+	e := Event{Name: "app1", IP: "10.0.0.1", Proto: "tcp", Opened: []string{"22", "80"}, Closed: []string{}}
+	w.Notify(e)
+	// Duplicate, not sent
+	w.Notify(e)
+	// No change, not sent
+	w.Notify(Event{Name: "app2", IP: "10.0.0.2", Proto: "tcp"})
+
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Errorf("got %d requests, want 2", calls)
+	}
+	want := `app1 opened 22,80 []`
+	if len(bodies) != 1 || bodies[0] != want {
+		t.Errorf("got bodies %q, want [%q]", bodies, want)
+	}
+}
+
+func TestWebhooks_isDuplicate(t *testing.T) {
+	w := Webhooks{sent: make(map[changeKey]time.Time), dedup: time.Hour}
+
+	tests := []struct {
+		name string
+		e    Event
+		want bool
+	}{
+		{name: "first", e: Event{Name: "app1", IP: "10.0.0.1", Proto: "tcp", Opened: []string{"22"}}},
+		{name: "same change", e: Event{Name: "app1", IP: "10.0.0.1", Proto: "tcp", Opened: []string{"22"}}, want: true},
+		{name: "other target", e: Event{Name: "app11", IP: "0.0.0.1", Proto: "tcp", Opened: []string{"22"}}},
+		{name: "other ports", e: Event{Name: "app1", IP: "10.0.0.1", Proto: "tcp", Opened: []string{"2", "2"}}},
+		{name: "closed instead", e: Event{Name: "app1", IP: "10.0.0.1", Proto: "tcp", Closed: []string{"22"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := w.isDuplicate(tt.e); got != tt.want {
+				t.Errorf("isDuplicate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhooks_Configure(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    config.Notifications
+		wantErr bool
+	}{
+		{name: "empty", conf: config.Notifications{}},
+		{name: "valid", conf: config.Notifications{DedupWindow: "10m", Webhooks: []config.Webhook{{URL: "http://localhost", Timeout: "5s"}}}},
+		{name: "no url", conf: config.Notifications{Webhooks: []config.Webhook{{}}}, wantErr: true},
+		{name: "invalid dedup window", conf: config.Notifications{DedupWindow: "1d"}, wantErr: true},
+		{name: "invalid template", conf: config.Notifications{Webhooks: []config.Webhook{{URL: "http://localhost", Template: "{{ .Name "}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateWebhooks(tt.conf); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateWebhooks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			w := Webhooks{}
+			if err := w.Configure(tt.conf); (err != nil) != tt.wantErr {
+				t.Errorf("Configure() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/devops-works/scan-exporter/logger"
+	"github.com/devops-works/scan-exporter/metrics"
+	"github.com/devops-works/scan-exporter/scan"
+	"github.com/devops-works/scan-exporter/sink"
+)
+
+// errUnexpectedPorts is returned by one-shot scans finding unexpected open or
+// closed ports, or unexpected services.
+var errUnexpectedPorts = errors.New("unexpected ports found")
+
+// runScan is the scan subcommand. It scans a single target given on the
+// command line, or all the targets of a configuration file, once.
+func runScan(args []string, stdout io.Writer) error {
+	var confFile, tgt, name, ports, expected, method, udpPorts, udpExpected, loglvl string
+	var timeout, limit, qps int
+	var out nmapOutputs
+
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	fs.StringVar(&confFile, "config", "", "path to config file, scanning all its targets")
+	fs.StringVar(&tgt, "target", "", "IP, CIDR, range or host name to scan")
+	fs.StringVar(&name, "name", "", "name of the target, defaults to -target")
+	fs.StringVar(&ports, "ports", "top1000", "TCP ports to scan, expected ports are always scanned")
+	fs.StringVar(&expected, "expected", "", "expected open TCP ports, optionally with their service like 443:tls")
+	fs.StringVar(&method, "method", "connect", "TCP scan method, connect or syn, which needs CAP_NET_RAW")
+	fs.StringVar(&udpPorts, "udp-ports", "", "UDP ports to scan, expected ports are always scanned")
+	fs.StringVar(&udpExpected, "udp-expected", "", "expected open UDP ports")
+	fs.IntVar(&timeout, "timeout", 2, "timeout of a port scan, in seconds")
+	fs.IntVar(&limit, "limit", 1024, "maximum number of ports scanned at the same time")
+	fs.IntVar(&qps, "qps", 0, "maximum number of ports scanned per second, 0 for no limit")
+	fs.StringVar(&out.xml, "oX", "", "write the results to a file in nmap XML format")
+	fs.StringVar(&out.grepable, "oG", "", "write the results to a file in nmap grepable format")
+	fs.StringVar(&loglvl, "log.lvl", "error", "log level. Can be {trace,debug,info,warn,error,fatal}")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if confFile != "" {
+		c, err := config.New(confFile)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", confFile, err)
+		}
+		return scanOnce(c, loglvl, out, stdout)
+	}
+
+	if tgt == "" {
+		return fmt.Errorf("-target or -config is required")
+	}
+	if name == "" {
+		name = tgt
+	}
+
+	t := config.Target{Name: name}
+	if isAddress(tgt) {
+		t.IP = tgt
+	} else {
+		t.Host = tgt
+	}
+	t.TCP.Expected = expected
+	t.TCP.Method = method
+	t.TCP.Range = strings.Trim(ports+","+t.TCP.ExpectedPorts(), ",")
+	if udpPorts != "" || udpExpected != "" {
+		t.UDP.Range = strings.Trim(udpPorts+","+udpExpected, ",")
+		t.UDP.Expected = udpExpected
+	}
+
+	c := &config.Conf{
+		Timeout:          timeout,
+		Limit:            limit,
+		QueriesPerSecond: qps,
+		// Periods are not used by one-shot scans, but must be valid
+		TcpPeriod: "1d",
+		UdpPeriod: "1d",
+		Targets:   []config.Target{t},
+	}
+	return scanOnce(c, loglvl, out, stdout)
+}
+
+// nmapOutputs are the files where the results of one-shot scans are written
+// in nmap formats. Empty paths are ignored.
+type nmapOutputs struct {
+	xml, grepable string
+}
+
+// collector is a sink keeping all the results in memory.
+type collector struct {
+	results []sink.Result
+}
+
+func (c *collector) Write(r sink.Result) error {
+	c.results = append(c.results, r)
+	return nil
+}
+
+// scanOnce scans all the targets of the configuration once, prints the
+// results and writes them to the nmap outputs. It returns errUnexpectedPorts
+// if any target has unexpected open or closed ports, or unexpected services.
+func scanOnce(c *config.Conf, loglvl string, out nmapOutputs, stdout io.Writer) error {
+	if c.LogLevel != "" {
+		loglvl = c.LogLevel
+	}
+
+	results := &collector{}
+	scanner := scan.Scanner{
+		Logger:      logger.New(loglvl),
+		MetricsServ: *metrics.Init(""),
+		Sinks:       []sink.Sink{results},
+	}
+	reports, err := scanner.Once(c)
+	if err != nil {
+		return err
+	}
+
+	if err := printReports(stdout, reports); err != nil {
+		return err
+	}
+
+	slices.SortFunc(results.results, func(a, b sink.Result) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.IP, b.IP), cmp.Compare(a.Proto, b.Proto))
+	})
+	if err := writeOutput(out.xml, sink.WriteNmapXML, results.results); err != nil {
+		return err
+	}
+	if err := writeOutput(out.grepable, sink.WriteNmapGrepable, results.results); err != nil {
+		return err
+	}
+
+	for _, r := range reports {
+		if len(r.UnexpectedOpen)+len(r.UnexpectedClosed)+len(r.UnexpectedServices) > 0 {
+			return errUnexpectedPorts
+		}
+	}
+	return nil
+}
+
+// writeOutput writes the results to path with the write function, if path is
+// set.
+func writeOutput(path string, write func(io.Writer, []sink.Result) error, results []sink.Result) error {
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, results); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// printReports writes the reports as a table.
+func printReports(w io.Writer, reports []scan.Report) error {
+	list := func(ports []string) string {
+		if len(ports) == 0 {
+			return "-"
+		}
+		return strings.Join(ports, ",")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tIP\tPROTO\tOPEN\tUNEXPECTED OPEN\tUNEXPECTED CLOSED\tUNEXPECTED SERVICES")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Name, r.IP, r.Proto,
+			list(r.Ports[metrics.StateOpen]), list(r.UnexpectedOpen), list(r.UnexpectedClosed), list(r.UnexpectedServices))
+	}
+	return tw.Flush()
+}
+
+// isAddress reports whether s is an IP, a CIDR or a range of IPs, rather than
+// a host name. IPv6 addresses can be enclosed in brackets.
+func isAddress(s string) bool {
+	if _, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")); err == nil {
+		return true
+	}
+	if _, err := netip.ParsePrefix(s); err == nil {
+		return true
+	}
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return false
+	}
+	_, err := netip.ParseAddr(start)
+	if err != nil {
+		return false
+	}
+	_, err = netip.ParseAddr(end)
+	return err == nil
+}
@@ -0,0 +1,174 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/devops-works/scan-exporter/metrics"
+	"github.com/devops-works/scan-exporter/sink"
+)
+
+// bannerWait is how long a service has to send its greeting before being
+// nudged.
+const bannerWait = time.Second
+
+// maxBannerLen is the maximum length of a banner, before escaping.
+const maxBannerLen = 128
+
+// bannerNudge is sent to services waiting for the client to speak first. Most
+// of them are HTTP servers, and the others usually answer with an error that
+// tells what they are.
+var bannerNudge = []byte("HEAD / HTTP/1.0\r\n\r\n")
+
+// portInfo is what has been learned about the service behind an open port.
+// tls is set when a TLS handshake succeeded, and http when the port has been
+// probed with an HTTP request.
+type portInfo struct {
+	banner  string
+	service string
+	tls     *metrics.TLSInfo
+	http    *metrics.HTTPInfo
+}
+
+// probes holds what has been learned about the open ports of an address, by
+// port.
+type probes map[string]portInfo
+
+// banners returns the banners of the ports that sent one, by port.
+func (p probes) banners() map[string]string {
+	banners := make(map[string]string)
+	for port, info := range p {
+		if info.banner != "" {
+			banners[port] = info.banner
+		}
+	}
+	return banners
+}
+
+// services returns the services of the probed ports, by port.
+func (p probes) services() map[string]string {
+	services := make(map[string]string)
+	for port, info := range p {
+		services[port] = info.service
+	}
+	return services
+}
+
+// tls returns what has been learned from the TLS ports, by port.
+func (p probes) tls() map[string]metrics.TLSInfo {
+	infos := make(map[string]metrics.TLSInfo)
+	for port, info := range p {
+		if info.tls != nil {
+			infos[port] = *info.tls
+		}
+	}
+	return infos
+}
+
+// http returns the answers of the HTTP probes, by port.
+func (p probes) http() map[string]metrics.HTTPInfo {
+	infos := make(map[string]metrics.HTTPInfo)
+	for port, info := range p {
+		if info.http != nil {
+			infos[port] = *info.http
+		}
+	}
+	return infos
+}
+
+// sink returns what has been learned about the probed ports in the form of
+// the export sinks, by port.
+func (p probes) sink() map[string]sink.Probe {
+	res := make(map[string]sink.Probe)
+	for port, info := range p {
+		sp := sink.Probe{Banner: info.banner, Service: info.service}
+		if h := info.http; h != nil {
+			sp.HTTP = &sink.HTTP{
+				Status:          h.Status,
+				Location:        h.Location,
+				Server:          h.Server,
+				Title:           h.Title,
+				ResponseSeconds: h.ResponseTime.Seconds(),
+				Error:           h.Error,
+				Unexpected:      h.Unexpected,
+			}
+		}
+		res[port] = sp
+	}
+	return res
+}
+
+// probe grabs the first line sent by the service behind conn, and identifies
+// the service. Services that stay silent for bannerWait are nudged, and have
+// until the timeout to answer. Services that do not answer the nudge are tried
+// with a TLS handshake on a new connection to addr, like the ones identified
+// as TLS. serverName is sent in TLS handshakes, if set.
+func (s *Scanner) probe(conn net.Conn, addr, serverName string) portInfo {
+	buf := make([]byte, 512)
+
+	conn.SetReadDeadline(time.Now().Add(min(bannerWait, s.Timeout)))
+	n, err := conn.Read(buf)
+	if n > 0 {
+		return portInfo{banner: cleanBanner(buf[:n]), service: greetingService(buf[:n])}
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		// Closed without a word
+		return portInfo{service: ServiceUnknown}
+	}
+
+	conn.SetDeadline(time.Now().Add(s.Timeout))
+	if _, err := conn.Write(bannerNudge); err == nil {
+		n, _ = conn.Read(buf)
+	}
+	info := portInfo{service: ServiceUnknown}
+	if n > 0 {
+		info = portInfo{banner: cleanBanner(buf[:n]), service: answerService(buf[:n])}
+		if info.service != ServiceTLS {
+			return info
+		}
+	}
+
+	if info.tls = s.inspectTLS(addr, serverName); info.tls != nil {
+		info.service = ServiceTLS
+	}
+	return info
+}
+
+// probeTLS tries a TLS handshake with an open port that is not probed
+// otherwise, and reports it as a TLS port with what has been learned from the
+// handshake if it succeeded. serverName is sent in the handshake, if set.
+func (s *Scanner) probeTLS(addr, serverName string) (portInfo, bool) {
+	info := s.inspectTLS(addr, serverName)
+	if info == nil {
+		return portInfo{}, false
+	}
+	return portInfo{service: ServiceTLS, tls: info}, true
+}
+
+// cleanBanner keeps the first non-empty line of b, truncated to maxBannerLen
+// bytes. Bytes that are not printable ASCII are escaped as \xNN, so binary
+// protocols still get a stable banner.
+func cleanBanner(b []byte) string {
+	line := strings.TrimLeft(string(b), "\r\n\t ")
+	if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+		line = line[:i]
+	}
+	if len(line) > maxBannerLen {
+		line = line[:maxBannerLen]
+	}
+	line = strings.TrimRight(line, "\t ")
+
+	var sb strings.Builder
+	for _, c := range []byte(line) {
+		if c >= ' ' && c <= '~' && c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, `\x%02x`, c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,122 @@
+package scan
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devops-works/scan-exporter/metrics"
+)
+
+// serve accepts connections on a local port and hands them to handle. If cfg
+// is set, connections use TLS. It returns the port.
+func serve(t *testing.T, cfg *tls.Config, handle func(net.Conn)) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	port := l.Addr().(*net.TCPAddr).Port
+	if cfg != nil {
+		l = tls.NewListener(l, cfg)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return port
+}
+
+func TestScanner_scanPort_probe(t *testing.T) {
+	greeting := serve(t, nil, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	})
+	// Waits for the client to speak first
+	http := serve(t, nil, func(conn net.Conn) {
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+			conn.Write([]byte("HTTP/1.0 200 OK\r\nServer: test\r\n\r\n"))
+		}
+	})
+	silent := serve(t, nil, func(conn net.Conn) {
+		time.Sleep(3 * time.Second)
+	})
+	https := httptest.NewUnstartedServer(nil)
+	// Keep the test output clean of the expected handshake errors
+	https.Config.ErrorLog = log.New(io.Discard, "", 0)
+	https.StartTLS()
+	defer https.Close()
+	// Hangs up on anything but a TLS handshake
+	handshake := serve(t, &tls.Config{Certificates: https.TLS.Certificates}, func(conn net.Conn) {
+		conn.(*tls.Conn).Handshake()
+	})
+
+	tests := []struct {
+		name   string
+		port   int
+		grab   bool
+		want   portInfo
+		wantOK bool
+	}{
+		{name: "greeting", port: greeting, grab: true, want: portInfo{banner: "SSH-2.0-OpenSSH_9.6", service: ServiceSSH}, wantOK: true},
+		{name: "nudged", port: http, grab: true, want: portInfo{banner: "HTTP/1.0 200 OK", service: ServiceHTTP}, wantOK: true},
+		{name: "https", port: https.Listener.Addr().(*net.TCPAddr).Port, grab: true, want: portInfo{banner: "HTTP/1.0 400 Bad Request", service: ServiceTLS}, wantOK: true},
+		{name: "tls handshake", port: handshake, grab: true, want: portInfo{service: ServiceTLS}, wantOK: true},
+		{name: "silent", port: silent, grab: true, want: portInfo{service: ServiceUnknown}, wantOK: true},
+		{name: "disabled", port: greeting, grab: false},
+		{name: "tls only", port: https.Listener.Addr().(*net.TCPAddr).Port, grab: false, want: portInfo{service: ServiceTLS}, wantOK: true},
+	}
+	s := Scanner{Timeout: 500 * time.Millisecond}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := make(chan result, 1)
+			got, ok := s.scanPort(&target{name: "app1"}, "127.0.0.1", tt.port, tt.grab, res)
+			if (got.tls != nil) != (tt.want.service == ServiceTLS) {
+				t.Errorf("scanPort() TLS info = %+v, want it only for TLS ports", got.tls)
+			}
+			got.tls = nil
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("scanPort() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+			if got := <-res; got.state != metrics.StateOpen {
+				t.Errorf("scanPort() = %+v, want an open port", got)
+			}
+		})
+	}
+}
+
+func Test_cleanBanner(t *testing.T) {
+	tests := []struct {
+		name string
+		b    string
+		want string
+	}{
+		{name: "empty", b: "", want: ""},
+		{name: "first line", b: "220 mail.example.com ESMTP\r\n250 ok\r\n", want: "220 mail.example.com ESMTP"},
+		{name: "leading blank lines", b: "\r\n\r\n+OK ready\n", want: "+OK ready"},
+		{name: "binary", b: "\x15\x03\x01\x00\x02\x02\x50", want: `\x15\x03\x01\x00\x02\x02P`},
+		{name: "backslash", b: `a\b`, want: `a\x5cb`},
+		{name: "truncated", b: strings.Repeat("a", 200), want: strings.Repeat("a", maxBannerLen)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanBanner([]byte(tt.b)); got != tt.want {
+				t.Errorf("cleanBanner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// lookupIPAddr resolves host names. It is replaced in tests.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// lookupHost resolves the A and AAAA records of a host. The addresses are
+// returned sorted so two resolutions can be compared. Link-local IPv6
+// addresses keep their zone, as they cannot be reached without it.
+func lookupHost(ctx context.Context, host string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ips, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []string{}
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	slices.Sort(addrs)
+
+	return slices.Compact(addrs), nil
+}
+
+// resolve returns the addresses to scan for a target. When the target is
+// defined by a host name, it is resolved again. If the set of addresses changed
+// since the previous scan, the change is logged and counted, and the series of
+// the addresses that disappeared are deleted.
+func (s *Scanner) resolve(t *target) ([]string, error) {
+	if t.host == "" {
+		return []string{t.ip}, nil
+	}
+
+	addrs, err := lookupHost(t.ctx, t.host, s.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve %s for %s: %w", t.host, t.name, err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address found for %s (%s)", t.host, t.name)
+	}
+
+	if t.addrs != nil && !slices.Equal(t.addrs, addrs) {
+		s.Logger.Warn().Str("name", t.name).Str("host", t.host).Strs("previous", t.addrs).Strs("current", addrs).
+			Msgf("%s (%s) resolved addresses changed from %s to %s", t.name, t.host, t.addrs, addrs)
+		s.MetricsServ.DNSChanges.WithLabelValues(t.name, t.host).Inc()
+
+		for _, old := range t.addrs {
+			if !slices.Contains(addrs, old) {
+				s.forgetAddr(t, old, "")
+			}
+		}
+	}
+
+	for _, addr := range addrs {
+		s.MetricsServ.TargetInfo.WithLabelValues(t.name, t.host, addr).Set(1)
+	}
+	t.addrs = addrs
+
+	return addrs, nil
+}
+
+// forget drops everything known about a target: stored results, history,
+// metrics and ping series and alerts, for each of its addresses. When proto is set,
+// only what is known about this protocol is dropped, as the target is still
+// scanned using the other one.
+func (s *Scanner) forget(t *target, proto string) {
+	if t.host == "" {
+		s.scanIsOver <- job{t: t, proto: proto, removed: true}
+		if proto == "" {
+			s.stopPingAddr(t, t.ip)
+		}
+		return
+	}
+	for _, addr := range t.addrs {
+		s.forgetAddr(t, addr, proto)
+	}
+	if proto == "" {
+		s.MetricsServ.DNSChanges.DeleteLabelValues(t.name, t.host)
+	}
+}
+
+// forgetAddr drops everything known about one of the resolved addresses of a
+// host target, or only about proto when it is set.
+func (s *Scanner) forgetAddr(t *target, addr, proto string) {
+	if proto == "" {
+		s.MetricsServ.TargetInfo.DeleteLabelValues(t.name, t.host, addr)
+		s.stopPingAddr(t, addr)
+	}
+	s.scanIsOver <- job{t: t.withIP(addr), proto: proto, removed: true}
+}
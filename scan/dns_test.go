@@ -0,0 +1,69 @@
+package scan
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/devops-works/scan-exporter/metrics"
+)
+
+func Test_lookupHost(t *testing.T) {
+	addrs, err := lookupHost(context.Background(), "localhost", time.Second)
+	if err != nil {
+		t.Fatalf("lookupHost() error = %v", err)
+	}
+	if !slices.Contains(addrs, "127.0.0.1") {
+		t.Errorf("lookupHost() = %v, want 127.0.0.1 in it", addrs)
+	}
+	if !slices.IsSorted(addrs) {
+		t.Errorf("lookupHost() = %v, want sorted addresses", addrs)
+	}
+}
+
+func TestScanner_forget(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    target
+		proto     string
+		wantJobs  []string
+		wantPings []string
+	}{
+		{name: "ip", target: target{name: "app1", ip: "198.51.100.42"}, wantJobs: []string{"198.51.100.42/"}, wantPings: []string{"198.51.100.42"}},
+		{name: "ip protocol", target: target{name: "app1", ip: "198.51.100.42"}, proto: "udp", wantJobs: []string{"198.51.100.42/udp"}},
+		{
+			name:      "host",
+			target:    target{name: "app1", host: "app1.example.com", addrs: []string{"198.51.100.42", "2001:db8::42"}},
+			wantJobs:  []string{"198.51.100.42/", "2001:db8::42/"},
+			wantPings: []string{"198.51.100.42", "2001:db8::42"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Scanner{
+				MetricsServ: *testServer(),
+				pchan:       make(chan metrics.PingInfo, 2),
+				scanIsOver:  make(chan job, 2),
+			}
+			s.forget(&tt.target, tt.proto)
+
+			var jobs, pings []string
+			for len(s.scanIsOver) > 0 {
+				j := <-s.scanIsOver
+				if !j.removed || j.t.name != "app1" {
+					t.Errorf("forget() sent %+v, want a removal of app1", j)
+				}
+				jobs = append(jobs, j.t.ip+"/"+j.proto)
+			}
+			for len(s.pchan) > 0 {
+				if pi := <-s.pchan; pi.Stopped && pi.Name == "app1" {
+					pings = append(pings, pi.IP)
+				}
+			}
+			if !slices.Equal(jobs, tt.wantJobs) || !slices.Equal(pings, tt.wantPings) {
+				t.Errorf("forget() removed %v and stopped pings of %v, want %v and %v", jobs, pings, tt.wantJobs, tt.wantPings)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package scan
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/devops-works/scan-exporter/metrics"
+)
+
+// maxBodyLen is how much of the page is read to find its title.
+const maxBodyLen = 64 << 10
+
+// titleRe matches the title of an HTML page.
+var titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// probeHTTP requests the HTTP path of a target on an open port, using HTTPS
+// if the service speaks TLS. Redirects are not followed. Ports that are not
+// web ports only get an answer, holding an error, when an answer is expected
+// from them. It returns nil otherwise.
+func (s *Scanner) probeHTTP(t *target, ip string, port int, service string) *metrics.HTTPInfo {
+	p := strconv.Itoa(port)
+	want, expected := t.expectedHTTP[p]
+
+	var info *metrics.HTTPInfo
+	switch service {
+	case ServiceHTTP:
+		info = s.getHTTP(ip, p, "http", t.host, t.httpPath)
+	case ServiceTLS:
+		info = s.getHTTP(ip, p, "https", t.host, t.httpPath)
+	default:
+		if !expected {
+			return nil
+		}
+		info = &metrics.HTTPInfo{Error: fmt.Sprintf("not a web port, service is %s", service)}
+	}
+
+	if expected {
+		info.Unexpected = checkHTTP(want, info)
+	}
+	return info
+}
+
+// getHTTP requests path on ip:port. The Host header and the name sent in TLS
+// handshakes are host, if set.
+func (s *Scanner) getHTTP(ip, port, scheme, host, path string) *metrics.HTTPInfo {
+	addr := net.JoinHostPort(ip, port)
+	if host == "" {
+		host = ip
+	}
+	client := &http.Client{
+		Timeout: s.Timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{Timeout: s.Timeout}
+				return d.DialContext(ctx, network, addr)
+			},
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, ServerName: host},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	info := &metrics.HTTPInfo{Scheme: scheme}
+	start := time.Now()
+	resp, err := client.Get(scheme + "://" + net.JoinHostPort(host, port) + path)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	defer resp.Body.Close()
+	info.ResponseTime = time.Since(start)
+
+	info.Status = resp.StatusCode
+	info.Location = resp.Header.Get("Location")
+	info.Server = resp.Header.Get("Server")
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxBodyLen))
+	info.Title = pageTitle(body)
+	return info
+}
+
+// pageTitle returns the title of an HTML page, unescaped, with its blanks
+// collapsed and truncated to maxBannerLen bytes.
+func pageTitle(body []byte) string {
+	m := titleRe.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	title := strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+	if len(title) > maxBannerLen {
+		title = strings.ToValidUTF8(title[:maxBannerLen], "")
+	}
+	return title
+}
+
+// checkHTTP returns the differences between an answer and the expected one.
+func checkHTTP(want config.ExpectedHTTP, got *metrics.HTTPInfo) []string {
+	if got.Error != "" {
+		return []string{"no HTTP answer"}
+	}
+
+	var diffs []string
+	if want.Status != 0 && got.Status != want.Status {
+		diffs = append(diffs, fmt.Sprintf("status %d, want %d", got.Status, want.Status))
+	}
+	if want.Redirect != "" && !strings.HasPrefix(got.Location, want.Redirect) {
+		diffs = append(diffs, fmt.Sprintf("redirect %q, want %q", got.Location, want.Redirect))
+	}
+	if want.Server != "" && !strings.Contains(got.Server, want.Server) {
+		diffs = append(diffs, fmt.Sprintf("server %q, want %q", got.Server, want.Server))
+	}
+	if want.Title != "" && !strings.Contains(got.Title, want.Title) {
+		diffs = append(diffs, fmt.Sprintf("title %q, want %q", got.Title, want.Title))
+	}
+	return diffs
+}
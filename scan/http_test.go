@@ -0,0 +1,120 @@
+package scan
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/devops-works/scan-exporter/metrics"
+)
+
+func TestScanner_probeHTTP(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.Redirect(w, r, "https://"+r.Host+r.URL.Path, http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Server", "test/1.0")
+		io.WriteString(w, "<html><head><title>\n  Health &amp; status\n</title></head></html>")
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewUnstartedServer(handler)
+	secure.Config.ErrorLog = log.New(io.Discard, "", 0)
+	secure.StartTLS()
+	defer secure.Close()
+	port := func(srv *httptest.Server) int {
+		return srv.Listener.Addr().(*net.TCPAddr).Port
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		port     int
+		service  string
+		expected *config.ExpectedHTTP
+		want     *metrics.HTTPInfo
+	}{
+		{
+			name: "title", path: "/health", port: port(plain), service: ServiceHTTP,
+			want: &metrics.HTTPInfo{Scheme: "http", Status: 200, Server: "test/1.0", Title: "Health & status"},
+		},
+		{
+			name: "https", path: "/health", port: port(secure), service: ServiceTLS,
+			expected: &config.ExpectedHTTP{Status: 200, Server: "test"},
+			want:     &metrics.HTTPInfo{Scheme: "https", Status: 200, Server: "test/1.0", Title: "Health & status"},
+		},
+		{
+			name: "redirect", path: "/", port: port(plain), service: ServiceHTTP,
+			expected: &config.ExpectedHTTP{Status: 301, Redirect: "https://app1.example.com"},
+			want:     &metrics.HTTPInfo{Scheme: "http", Status: 301, Location: "https://app1.example.com:" + strconv.Itoa(port(plain)) + "/"},
+		},
+		{
+			name: "unexpected", path: "/", port: port(plain), service: ServiceHTTP,
+			expected: &config.ExpectedHTTP{Status: 200, Title: "Welcome"},
+			want: &metrics.HTTPInfo{Scheme: "http", Status: 301, Location: "https://app1.example.com:" + strconv.Itoa(port(plain)) + "/",
+				Unexpected: []string{"status 301, want 200", `title "", want "Welcome"`}},
+		},
+		{
+			name: "not a web port", path: "/", port: 22, service: ServiceSSH,
+			expected: &config.ExpectedHTTP{Status: 200},
+			want:     &metrics.HTTPInfo{Error: "not a web port, service is ssh", Unexpected: []string{"no HTTP answer"}},
+		},
+		{name: "not expected", path: "/", port: 22, service: ServiceSSH},
+	}
+	s := Scanner{Timeout: time.Second}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := &target{host: "app1.example.com", httpPath: tt.path, expectedHTTP: map[string]config.ExpectedHTTP{}}
+			if tt.expected != nil {
+				tg.expectedHTTP[strconv.Itoa(tt.port)] = *tt.expected
+			}
+
+			got := s.probeHTTP(tg, "127.0.0.1", tt.port, tt.service)
+			if got == nil || tt.want == nil {
+				if got != tt.want {
+					t.Fatalf("probeHTTP() = %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			if got.ResponseTime <= 0 && got.Error == "" {
+				t.Errorf("probeHTTP() response time = %v, want it measured", got.ResponseTime)
+			}
+			got.ResponseTime = 0
+			if got.Scheme != tt.want.Scheme || got.Status != tt.want.Status || got.Location != tt.want.Location ||
+				got.Server != tt.want.Server || got.Title != tt.want.Title || got.Error != tt.want.Error ||
+				!slices.Equal(got.Unexpected, tt.want.Unexpected) {
+				t.Errorf("probeHTTP() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pageTitle(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "none", body: "<html></html>", want: ""},
+		{name: "simple", body: "<title>Home</title>", want: "Home"},
+		{name: "attributes and case", body: `<TITLE lang="en">Home</TITLE>`, want: "Home"},
+		{name: "blanks and entities", body: "<title>\n\tA &lt;b&gt;  c\n</title>", want: "A <b> c"},
+		{name: "truncated", body: "<title>é" + strings.Repeat("a", 200) + "</title>", want: "é" + strings.Repeat("a", maxBannerLen-2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pageTitle([]byte(tt.body)); got != tt.want {
+				t.Errorf("pageTitle() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
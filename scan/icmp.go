@@ -11,6 +11,7 @@ import (
 
 // ping realises an ICMP echo request to a specified target.
 // Each error is followed by a continue, which will not stop the goroutine.
+// It returns when the target's context is cancelled.
 func (t *target) ping(logger zerolog.Logger, timeout time.Duration, pchan chan metrics.PingInfo) {
 	p, err := getDuration(t.icmpPeriod)
 	if err != nil {
@@ -24,48 +25,73 @@ func (t *target) ping(logger zerolog.Logger, timeout time.Duration, pchan chan m
 	randPeriod := p + (time.Duration(n) * time.Millisecond)
 
 	ticker := time.NewTicker(randPeriod)
+	defer ticker.Stop()
 
 	for {
 		select {
+		case <-t.ctx.Done():
+			return
 		case <-ticker.C:
-			pinfo := metrics.PingInfo{
-				Name:         t.name,
-				IP:           t.ip,
-				IsResponding: false,
-				RTT:          0,
+			addrs := []string{t.ip}
+			if t.host != "" {
+				addrs, err = lookupHost(t.ctx, t.host, timeout)
+				if err != nil {
+					logger.Error().Err(err).Msgf("cannot resolve %s for %s", t.host, t.name)
+					continue
+				}
 			}
 
-			pinger, err := ping.NewPinger(t.ip)
-			if err != nil {
-				logger.Error().Err(err).Msgf("error creating pinger for %s (%s)", t.name, t.ip)
-				continue
+			for _, addr := range addrs {
+				t.pingAddr(logger, addr, timeout, pchan)
 			}
+		}
+	}
+}
 
-			pinger.Timeout = timeout
-			pinger.SetPrivileged(true)
-			pinger.Count = 3
+// pingAddr sends ICMP echo requests to one of the target's addresses and
+// sends the result to pchan.
+func (t *target) pingAddr(logger zerolog.Logger, ip string, timeout time.Duration, pchan chan metrics.PingInfo) {
+	pinfo := metrics.PingInfo{
+		Name:         t.name,
+		IP:           ip,
+		IsResponding: false,
+		RTT:          0,
+	}
 
-			pinger.OnFinish = func(stats *ping.Statistics) {
-				logger.Debug().Str("name", t.name).Str("ip", t.ip).Msgf("ping ended")
-				pinfo.RTT = stats.AvgRtt
-				if stats.AvgRtt != 0 {
-					pinfo.IsResponding = true
-				} else {
-					pinfo.IsResponding = false
-				}
-				pchan <- pinfo
-			}
+	pinger, err := ping.NewPinger(ip)
+	if err != nil {
+		logger.Error().Err(err).Msgf("error creating pinger for %s (%s)", t.name, ip)
+		return
+	}
 
-			pinger.OnRecv = func(p *ping.Packet) {
-				logger.Debug().Str("name", t.name).Str("ip", t.ip).Msgf("received one ICMP reply")
-			}
+	pinger.Timeout = timeout
+	pinger.SetPrivileged(true)
+	pinger.Count = 3
 
-			logger.Debug().Str("name", t.name).Str("ip", t.ip).Msgf("running a new ping")
-			err = pinger.Run()
-			if err != nil {
-				logger.Error().Err(err).Msgf("error running pinger for %s (%s)", t.name, t.ip)
-				continue
-			}
+	pinger.OnFinish = func(stats *ping.Statistics) {
+		logger.Debug().Str("name", t.name).Str("ip", ip).Msgf("ping ended")
+		pinfo.RTT = stats.AvgRtt
+		if stats.AvgRtt != 0 {
+			pinfo.IsResponding = true
+		} else {
+			pinfo.IsResponding = false
 		}
+		// Do not resurrect the metrics of a removed target
+		t.pingMu.Lock()
+		defer t.pingMu.Unlock()
+		if t.ctx.Err() != nil {
+			return
+		}
+		pchan <- pinfo
+	}
+
+	pinger.OnRecv = func(p *ping.Packet) {
+		logger.Debug().Str("name", t.name).Str("ip", ip).Msgf("received one ICMP reply")
+	}
+
+	logger.Debug().Str("name", t.name).Str("ip", ip).Msgf("running a new ping")
+	err = pinger.Run()
+	if err != nil {
+		logger.Error().Err(err).Msgf("error running pinger for %s (%s)", t.name, ip)
 	}
 }
@@ -0,0 +1,121 @@
+package scan
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strconv"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/devops-works/scan-exporter/metrics"
+	"github.com/devops-works/scan-exporter/storage"
+)
+
+// Report is the result of a one-shot scan of an IP of a target using a
+// protocol. Ports holds the scanned ports by state. UnexpectedServices holds
+// the open ports not running their expected service, as port:service.
+type Report struct {
+	Name               string
+	IP                 string
+	Proto              string
+	Ports              map[string][]string
+	Expected           []string
+	UnexpectedOpen     []string
+	UnexpectedClosed   []string
+	UnexpectedServices []string
+}
+
+// Once scans every target of the configuration exactly once, using TCP and
+// UDP, and returns the reports sorted by target name, IP and protocol, with
+// their ports in ascending order. Targets are not pinged. Metrics and sinks
+// are updated, but not the store nor the history.
+func (s *Scanner) Once(c *config.Conf) ([]Report, error) {
+	if err := s.configure(c); err != nil {
+		return nil, err
+	}
+
+	targets, err := s.newTargets(c)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []job{}
+	for _, t := range targets {
+		t.ctx, t.cancel = context.WithCancel(context.Background())
+		defer t.cancel()
+
+		if t.doTCP {
+			jobs = append(jobs, job{t: t, proto: "tcp"})
+		}
+		if t.doUDP {
+			jobs = append(jobs, job{t: t, proto: "udp"})
+		}
+	}
+	s.Targets = targets
+
+	scanIsOver := make(chan job, len(jobs))
+	singleResult := make(chan result, c.Limit)
+	mchan := make(chan metrics.NewMetrics, len(jobs))
+	go receiver(scanIsOver, singleResult, mchan, storage.Create(), nil, s.Sinks)
+
+	// scanned is sent the number of scanned addresses once all the jobs are
+	// done
+	scanned := make(chan int, 1)
+	go func() {
+		n := 0
+		for _, j := range jobs {
+			s.Logger.Debug().Msgf("starting %s scan for %s", j.proto, j.t.address())
+			count, err := s.run(j, scanIsOver, singleResult)
+			if err != nil {
+				s.Logger.Error().Err(err).Msgf("error running %s scan of %s", j.proto, j.t.address())
+			}
+			n += count
+		}
+		scanned <- n
+	}()
+
+	reports := []Report{}
+	for want := -1; want < 0 || len(reports) < want; {
+		select {
+		case nm := <-mchan:
+			for _, ports := range nm.Ports {
+				slices.SortFunc(ports, comparePorts)
+			}
+			open, closed := nm.Unexpected()
+			reports = append(reports, Report{
+				Name:               nm.Name,
+				IP:                 nm.IP,
+				Proto:              nm.Proto,
+				Ports:              nm.Ports,
+				Expected:           nm.Expected,
+				UnexpectedOpen:     open,
+				UnexpectedClosed:   closed,
+				UnexpectedServices: nm.UnexpectedServices(),
+			})
+		case want = <-scanned:
+		}
+	}
+
+	// All the scans are over
+	if s.syn != nil {
+		s.syn.close()
+		s.syn = nil
+	}
+
+	slices.SortFunc(reports, func(a, b Report) int {
+		return cmp.Or(
+			cmp.Compare(a.Name, b.Name),
+			cmp.Compare(a.IP, b.IP),
+			cmp.Compare(a.Proto, b.Proto),
+		)
+	})
+
+	return reports, nil
+}
+
+// comparePorts orders ports numerically.
+func comparePorts(a, b string) int {
+	pa, _ := strconv.Atoi(a)
+	pb, _ := strconv.Atoi(b)
+	return cmp.Compare(pa, pb)
+}
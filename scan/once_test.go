@@ -0,0 +1,174 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/devops-works/scan-exporter/metrics"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+)
+
+// listen returns a listening and a closed TCP port on the loopback address ip.
+func listen(t *testing.T, ip string) (open, closed string) {
+	t.Helper()
+	l, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", ip, err)
+	}
+	t.Cleanup(func() { l.Close() })
+	open = strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+
+	c, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed = strconv.Itoa(c.Addr().(*net.TCPAddr).Port)
+	c.Close()
+	return open, closed
+}
+
+func TestScanner_Once(t *testing.T) {
+	s := Scanner{MetricsServ: *testServer()}
+
+	tests := []struct {
+		name string
+		ip   string
+		// addr is how the address is written in the configuration
+		addr string
+	}{
+		{name: "ipv4", ip: "127.0.0.1", addr: "127.0.0.1"},
+		{name: "ipv6", ip: "::1", addr: "0:0::1"},
+		{name: "ipv6 brackets", ip: "::1", addr: "[::1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openPort, closedPort := listen(t, tt.ip)
+
+			c := &config.Conf{
+				Timeout:   1,
+				Limit:     10,
+				TcpPeriod: "1d",
+				Targets: []config.Target{
+					{Name: "unexpected", IP: tt.addr},
+					{Name: "expected", IP: tt.addr},
+				},
+			}
+			c.Targets[0].TCP.Range = openPort + "," + closedPort
+			c.Targets[0].TCP.Expected = closedPort
+			c.Targets[1].TCP.Range = openPort
+			c.Targets[1].TCP.Expected = openPort
+
+			reports, err := s.Once(c)
+			if err != nil {
+				t.Fatalf("Once() error = %v", err)
+			}
+
+			if len(reports) != 2 {
+				t.Fatalf("got %d reports, want 2", len(reports))
+			}
+			tests := []struct {
+				report           Report
+				name             string
+				unexpectedOpen   []string
+				unexpectedClosed []string
+			}{
+				{report: reports[0], name: "expected"},
+				{report: reports[1], name: "unexpected", unexpectedOpen: []string{openPort}, unexpectedClosed: []string{closedPort}},
+			}
+			for _, want := range tests {
+				r := want.report
+				if r.Name != want.name || r.IP != tt.ip || r.Proto != "tcp" {
+					t.Errorf("got report for %s (%s) %s, want %s (%s) tcp", r.Name, r.IP, r.Proto, want.name, tt.ip)
+				}
+				if !slices.Equal(r.Ports[metrics.StateOpen], []string{openPort}) {
+					t.Errorf("%s: open ports = %v, want [%s]", want.name, r.Ports[metrics.StateOpen], openPort)
+				}
+				if !slices.Equal(r.UnexpectedOpen, want.unexpectedOpen) || !slices.Equal(r.UnexpectedClosed, want.unexpectedClosed) {
+					t.Errorf("%s: unexpected ports = %v/%v, want %v/%v", want.name, r.UnexpectedOpen, r.UnexpectedClosed, want.unexpectedOpen, want.unexpectedClosed)
+				}
+			}
+		})
+	}
+
+	// A dual-stack host is scanned on both its addresses
+	t.Run("dual-stack host", func(t *testing.T) {
+		open4, _ := listen(t, "127.0.0.1")
+		l, err := net.Listen("tcp", net.JoinHostPort("::1", open4))
+		if err != nil {
+			t.Skipf("cannot listen on ::1: %v", err)
+		}
+		defer l.Close()
+
+		lookup := lookupIPAddr
+		defer func() { lookupIPAddr = lookup }()
+		lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			return []net.IPAddr{{IP: net.IPv6loopback}, {IP: net.IPv4(127, 0, 0, 1)}}, nil
+		}
+
+		c := &config.Conf{Timeout: 1, Limit: 10, TcpPeriod: "1d", Targets: []config.Target{{Name: "dual", Host: "dual.example.com"}}}
+		c.Targets[0].TCP.Range = open4
+		reports, err := s.Once(c)
+		if err != nil {
+			t.Fatalf("Once() error = %v", err)
+		}
+		if len(reports) != 2 || reports[0].IP != "127.0.0.1" || reports[1].IP != "::1" {
+			t.Fatalf("got reports %+v, want one for 127.0.0.1 and one for ::1", reports)
+		}
+		for _, r := range reports {
+			if !slices.Equal(r.Ports[metrics.StateOpen], []string{open4}) {
+				t.Errorf("%s: open ports = %v, want [%s]", r.IP, r.Ports[metrics.StateOpen], open4)
+			}
+		}
+	})
+}
+
+func TestScanner_Once_synFallback(t *testing.T) {
+	// Raw sockets failed to open on a previous configuration
+	var logs bytes.Buffer
+	s := Scanner{MetricsServ: *testServer(), Logger: zerolog.New(&logs), synErr: errors.New("operation not permitted")}
+	openPort, _ := listen(t, "127.0.0.1")
+
+	c := &config.Conf{
+		Timeout:   1,
+		Limit:     10,
+		TcpPeriod: "1d",
+		Targets: []config.Target{
+			{Name: "fallback-syn", IP: "127.0.0.1"},
+			{Name: "fallback-connect", IP: "127.0.0.1"},
+		},
+	}
+	c.Targets[0].TCP.Range, c.Targets[0].TCP.Method = openPort, MethodSYN
+	c.Targets[1].TCP.Range = openPort
+
+	reports, err := s.Once(c)
+	if err != nil {
+		t.Fatalf("Once() error = %v", err)
+	}
+	for _, r := range reports {
+		if !slices.Equal(r.Ports[metrics.StateOpen], []string{openPort}) {
+			t.Errorf("%s: open ports = %v, want [%s]", r.Name, r.Ports[metrics.StateOpen], openPort)
+		}
+	}
+
+	if s.syn != nil || strings.Contains(logs.String(), "CAP_NET_RAW") {
+		t.Errorf("SYN scans were tried again, logs: %s", logs.String())
+	}
+	var m dto.Metric
+	if err := s.MetricsServ.SYNFallback.WithLabelValues("fallback-syn", "127.0.0.1").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetGauge().GetValue(); got != 1 {
+		t.Errorf("scanexporter_syn_fallback of the SYN target = %v, want 1", got)
+	}
+	if s.MetricsServ.SYNFallback.DeleteLabelValues("fallback-connect", "127.0.0.1") {
+		t.Errorf("scanexporter_syn_fallback is set for a connect target")
+	}
+}
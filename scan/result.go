@@ -0,0 +1,113 @@
+package scan
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/devops-works/scan-exporter/metrics"
+	"github.com/devops-works/scan-exporter/sink"
+)
+
+// result is the scan of a single port of an IP of a target, using a protocol.
+// name is the name of the target, which tells apart targets sharing an IP.
+// latency is the time it took to connect, or to be refused or answered, and
+// reason why the port is not open, if it is not. time is when the scan of the
+// port completed.
+type result struct {
+	name    string
+	ip      string
+	port    int
+	proto   string
+	state   string
+	latency time.Duration
+	reason  string
+	time    time.Time
+}
+
+// newResult returns the result of the scan of a port that began at start.
+func newResult(name, ip string, port int, proto, state, reason string, start time.Time) result {
+	now := time.Now()
+	return result{
+		name:    name,
+		ip:      ip,
+		port:    port,
+		proto:   proto,
+		state:   state,
+		latency: now.Sub(start),
+		reason:  reason,
+		time:    now,
+	}
+}
+
+// key returns the key of the results of the target, IP and protocol of the
+// result.
+func (r result) key() string {
+	return resultKey(r.name, r.ip, r.proto)
+}
+
+// dialState classifies a connection error into a port state, and the reason
+// why the port is not open.
+func dialState(err error) (state, reason string) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return metrics.StateClosed, metrics.ReasonRefused
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return metrics.StateFiltered, metrics.ReasonTimeout
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.EHOSTDOWN):
+		return metrics.StateError, metrics.ReasonHostUnreachable
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.ENETDOWN):
+		return metrics.StateError, metrics.ReasonNetworkUnreachable
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return metrics.StateError, metrics.ReasonPermission
+	default:
+		return metrics.StateError, metrics.ReasonOther
+	}
+}
+
+// byState returns the scanned ports by state, in numerical order, and the
+// number of ports in error by reason.
+func byState(results []result) (map[string][]string, map[string]int) {
+	ports := make(map[string][]string)
+	errs := make(map[string]int)
+	for _, r := range results {
+		ports[r.state] = append(ports[r.state], strconv.Itoa(r.port))
+		if r.state == metrics.StateError {
+			errs[r.reason]++
+		}
+	}
+	for _, p := range ports {
+		slices.SortFunc(p, comparePorts)
+	}
+	return ports, errs
+}
+
+// sinkPorts returns the scanned ports in the form of the export sinks.
+func sinkPorts(results []result, expected []string) []sink.Port {
+	ports := []sink.Port{}
+	for _, r := range results {
+		ports = append(ports, sink.Port{
+			Port:     r.port,
+			State:    r.state,
+			Expected: slices.Contains(expected, strconv.Itoa(r.port)),
+			Latency:  r.latency.Seconds(),
+			Reason:   r.reason,
+		})
+	}
+	return ports
+}
+
+// openLatencies returns the latency of the open ports, by port.
+func openLatencies(results []result) map[string]time.Duration {
+	latencies := make(map[string]time.Duration)
+	for _, r := range results {
+		if r.state == metrics.StateOpen {
+			latencies[strconv.Itoa(r.port)] = r.latency
+		}
+	}
+	return latencies
+}
@@ -0,0 +1,62 @@
+package scan
+
+import (
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devops-works/scan-exporter/metrics"
+	"github.com/devops-works/scan-exporter/sink"
+)
+
+func Test_byState(t *testing.T) {
+	results := []result{
+		{ip: "10.0.0.1", port: 1000, proto: "tcp", state: metrics.StateOpen},
+		{ip: "10.0.0.1", port: 22, proto: "tcp", state: metrics.StateOpen},
+		{ip: "10.0.0.1", port: 80, proto: "tcp", state: metrics.StateClosed, reason: metrics.ReasonRefused},
+		{ip: "10.0.0.1", port: 443, proto: "tcp", state: metrics.StateOpen},
+		{ip: "10.0.0.1", port: 8080, proto: "tcp", state: metrics.StateError, reason: metrics.ReasonHostUnreachable},
+		{ip: "10.0.0.1", port: 8443, proto: "tcp", state: metrics.StateError, reason: metrics.ReasonHostUnreachable},
+	}
+
+	ports, errs := byState(results)
+	wantPorts := map[string][]string{
+		metrics.StateOpen:   {"22", "443", "1000"},
+		metrics.StateClosed: {"80"},
+		metrics.StateError:  {"8080", "8443"},
+	}
+	if !reflect.DeepEqual(ports, wantPorts) {
+		t.Errorf("byState() ports = %v, want %v", ports, wantPorts)
+	}
+	if want := map[string]int{metrics.ReasonHostUnreachable: 2}; !maps.Equal(errs, want) {
+		t.Errorf("byState() errors = %v, want %v", errs, want)
+	}
+}
+
+func Test_sinkPorts(t *testing.T) {
+	results := []result{
+		{ip: "10.0.0.1", port: 22, proto: "tcp", state: metrics.StateOpen, latency: 1500 * time.Microsecond},
+		{ip: "10.0.0.1", port: 80, proto: "tcp", state: metrics.StateFiltered, reason: metrics.ReasonTimeout, latency: time.Second},
+	}
+
+	want := []sink.Port{
+		{Port: 22, State: metrics.StateOpen, Expected: true, Latency: 0.0015},
+		{Port: 80, State: metrics.StateFiltered, Latency: 1, Reason: metrics.ReasonTimeout},
+	}
+	if got := sinkPorts(results, []string{"22"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("sinkPorts() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_openLatencies(t *testing.T) {
+	results := []result{
+		{ip: "10.0.0.1", port: 22, proto: "tcp", state: metrics.StateOpen, latency: time.Millisecond},
+		{ip: "10.0.0.1", port: 80, proto: "tcp", state: metrics.StateClosed, reason: metrics.ReasonRefused, latency: 2 * time.Millisecond},
+	}
+
+	want := map[string]time.Duration{"22": time.Millisecond}
+	if got := openLatencies(results); !maps.Equal(got, want) {
+		t.Errorf("openLatencies() = %v, want %v", got, want)
+	}
+}
@@ -3,8 +3,10 @@ package scan
 import (
 	"context"
 	"fmt"
+	"maps"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,68 +15,275 @@ import (
 	"github.com/devops-works/scan-exporter/common"
 	"github.com/devops-works/scan-exporter/config"
 	"github.com/devops-works/scan-exporter/metrics"
+	"github.com/devops-works/scan-exporter/sink"
 	"github.com/devops-works/scan-exporter/storage"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/semaphore"
 )
 
+// defaultMaxHosts is the maximum number of hosts a single configured target
+// can expand to when max_hosts is not set.
+const defaultMaxHosts = 1024
+
+// defaultPortStateLimit is the maximum number of per-port series of a target
+// and protocol when port_state_limit is not set.
+const defaultPortStateLimit = 100
+
 type target struct {
-	ip         string
-	name       string
-	ports      string
-	expected   []string
-	doTCP      bool
-	doPing     bool
-	tcpPeriod  string
-	icmpPeriod string
-	qps        int
+	ip          string
+	host        string
+	name        string
+	ports       string
+	expected    []string
+	services    map[string]string
+	udpPorts    string
+	udpExpected []string
+	doTCP       bool
+	doUDP       bool
+	doPing      bool
+	tcpPeriod   string
+	udpPeriod   string
+	icmpPeriod  string
+	qps         int
+	banners     bool
+	// tcpMethod is how TCP ports are scanned, MethodConnect or MethodSYN
+	tcpMethod string
+
+	// httpPorts holds the TCP ports probed with an HTTP request of httpPath,
+	// and expectedHTTP the answers expected from some of them, by port
+	httpPorts    map[string]bool
+	httpPath     string
+	expectedHTTP map[string]config.ExpectedHTTP
+
+	// portStateLimit is the maximum number of per-port series, 0 disables
+	// them
+	portStateLimit int
+	// portLatency enables the per-port connect time series of the expected
+	// ports
+	portLatency bool
+
+	// addrs holds the addresses host resolved to during the last scan
+	addrs []string
+
+	// ctx is cancelled when the target is removed from the configuration or
+	// replaced by a new version of itself. It stops its schedulers and pinger.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// pingMu orders the ping results of the target with the deletion of its
+	// ping series
+	pingMu *sync.Mutex
+}
+
+// job is a scan of all the ports of a target using a protocol, either "tcp" or
+// "udp". Once the scan of an address is over, start holds the time it began,
+// method how its TCP ports were scanned and probes what has been learned about
+// its open ports. When removed is set, the address is not scanned anymore and
+// the receiver forgets it instead.
+type job struct {
+	t       *target
+	proto   string
+	start   time.Time
+	method  string
+	probes  probes
+	removed bool
 }
 
 // Scanner holds the targets list, global settings such as timeout and lock size,
-// the logger and the metrics server.
+// the logger, the metrics server, the store of previous results, the scan
+// history and the sinks receiving every result. If Store is nil, results are
+// kept in memory. If History is nil, no history is kept.
 type Scanner struct {
-	Targets     []target
+	Targets     map[string]*target
 	Timeout     time.Duration
 	Lock        *semaphore.Weighted
 	Logger      zerolog.Logger
 	MetricsServ metrics.Server
+	Store       storage.Backend
+	History     *storage.History
+	Sinks       []sink.Sink
+
+	trigger    chan job
+	pchan      chan metrics.PingInfo
+	scanIsOver chan job
+
+	reloads    chan *config.Conf
+	reloadOnce sync.Once
+
+	// tlsMinVersion is the oldest TLS version that is not weak
+	tlsMinVersion uint16
+	// syn sends the SYNs of SYN scans. It is nil when no target uses them,
+	// or when raw sockets cannot be opened, and synErr is then why, so they
+	// are not tried again on reloads.
+	syn    *synScanner
+	synErr error
 }
 
 // Start configure targets and launches scans.
 func (s *Scanner) Start(c *config.Conf) error {
+	if err := s.configure(c); err != nil {
+		return err
+	}
 
-	s.Logger.Info().Msgf("%d target(s) found in configuration file", len(c.Targets))
-	s.MetricsServ.NumOfTargets.Set(float64(len(c.Targets)))
+	targets, err := s.newTargets(c)
+	if err != nil {
+		return err
+	}
+
+	// If an ICMP period has been provided, it means that we want to ping the
+	// target. But before, we need to check if we have enough privileges.
+	if os.Geteuid() != 0 {
+		s.Logger.Warn().Msgf("scan-exporter not launched as superuser, ICMP requests can fail")
+	}
+
+	// ping channel to send ICMP update to metrics
+	s.pchan = make(chan metrics.PingInfo, len(c.Targets)*2)
+
+	s.trigger = make(chan job, len(c.Targets)*2)
+
+	// scanIsOver is used by s.run() to notify the receiver that all the ports
+	// have been scanned, and by s.forget() that a target is gone from the
+	// configuration. Both go through the same channel so a removal is never
+	// overtaken by the end of a scan that came before it.
+	s.scanIsOver = make(chan job, len(c.Targets))
+
+	// singleResult is used by s.scanPort() and s.scanUDPPort() to send the
+	// result of each port to the receiver
+	singleResult := make(chan result, c.Limit)
+
+	// Create channel for communication with metrics server
+	mchan := make(chan metrics.NewMetrics, len(c.Targets)*2)
+
+	// Channel that will hold the number of scans in the waiting line (len of
+	// the trigger chan)
+	pendingchan := make(chan int, len(c.Targets))
+
+	// Goroutine that will send to metrics the number of pendings scan
+	go func() {
+		for {
+			time.Sleep(500 * time.Millisecond)
+			pendingchan <- len(s.trigger)
+		}
+	}()
+
+	// Start the metrics updater
+	go s.MetricsServ.Updater(mchan, s.pchan, pendingchan)
+
+	// Start the receiver
+	if s.Store == nil {
+		s.Store = storage.Create()
+	}
+	go receiver(s.scanIsOver, singleResult, mchan, s.Store, s.History, s.Sinks)
+
+	s.apply(targets)
+
+	// Wait for triggers, build the scanner and run it. Configuration reloads
+	// are handled here too, so targets are never swapped during a scan.
+	for {
+		select {
+		case j := <-s.trigger:
+			// The target has been removed or replaced since it has been
+			// triggered
+			if j.t.ctx.Err() != nil {
+				continue
+			}
+			s.Logger.Debug().Msgf("starting new %s scan for %s", j.proto, j.t.address())
+			if _, err := s.run(j, s.scanIsOver, singleResult); err != nil {
+				s.Logger.Error().Err(err).Msg("error running scan")
+			}
+		case nc := <-s.reloadChan():
+			if err := s.reload(nc); err != nil {
+				s.Logger.Error().Err(err).Msg("cannot reload configuration, keeping the current one")
+			}
+		}
+	}
+}
+
+// Reload asks the scanner to apply a new configuration. It blocks until the
+// scanner is ready to handle it, which can take a while if a scan is running.
+func (s *Scanner) Reload(c *config.Conf) {
+	s.reloadChan() <- c
+}
+
+func (s *Scanner) reloadChan() chan *config.Conf {
+	s.reloadOnce.Do(func() {
+		s.reloads = make(chan *config.Conf)
+	})
+	return s.reloads
+}
 
+// reload applies a new configuration on a running scanner. Nothing is changed
+// if the configuration is invalid.
+func (s *Scanner) reload(c *config.Conf) error {
+	targets, err := s.newTargets(c)
+	if err != nil {
+		return err
+	}
+	if err := s.configure(c); err != nil {
+		return err
+	}
+
+	s.Logger.Info().Msgf("configuration reloaded")
+	s.apply(targets)
+	return nil
+}
+
+// configure sets the scanner's global values from the configuration.
+func (s *Scanner) configure(c *config.Conf) error {
 	// Check if shared values are set
 	if c.Timeout == 0 {
-		s.Logger.Fatal().Msgf("no timeout provided in configuration file")
+		return fmt.Errorf("no timeout provided in configuration file")
 	}
 	if c.Limit == 0 {
-		s.Logger.Fatal().Msgf("no limit provided in configuration file")
+		return fmt.Errorf("no limit provided in configuration file")
 	}
+	tlsMinVersion, err := parseTLSVersion(c.TLS.MinVersion)
+	if err != nil {
+		return err
+	}
+	s.tlsMinVersion = tlsMinVersion
 	s.Lock = semaphore.NewWeighted(int64(c.Limit))
 	s.Timeout = time.Second * time.Duration(c.Timeout)
 
-	// If an ICMP period has been provided, it means that we want to ping the
-	// target. But before, we need to check if we have enough privileges.
-	if os.Geteuid() != 0 {
-		s.Logger.Warn().Msgf("scan-exporter not launched as superuser, ICMP requests can fail")
+	// Raw sockets are opened when a target first needs them, and not tried
+	// again if they cannot be. They are closed when no target needs them
+	// anymore. Scans are not running while the scanner is configured.
+	needSYN := slices.ContainsFunc(c.Targets, func(t config.Target) bool { return t.TCP.Method == MethodSYN })
+	switch {
+	case needSYN && s.syn == nil && s.synErr == nil:
+		if s.syn, s.synErr = newSynScanner(); s.synErr != nil {
+			s.Logger.Warn().Err(s.synErr).Msg("SYN scans need the CAP_NET_RAW capability, falling back to connect scans")
+		}
+	case !needSYN && s.syn != nil:
+		s.syn.close()
+		s.syn = nil
 	}
 
-	// ping channel to send ICMP update to metrics
-	pchan := make(chan metrics.PingInfo, len(c.Targets)*2)
+	s.Logger.Info().Msgf("%d target(s) found in configuration file", len(c.Targets))
+
+	return nil
+}
+
+// newTargets builds the local target objects from the configuration. They are
+// indexed by their key. Targets whose IP is a CIDR or a range are expanded into
+// one target per host, sharing the parent's settings.
+func (s *Scanner) newTargets(c *config.Conf) (map[string]*target, error) {
+	targets := make(map[string]*target)
 
-	// Configure local target objects
 	for _, t := range c.Targets {
-		target := target{
-			ip:         t.IP,
-			name:       t.Name,
-			tcpPeriod:  t.TCP.Period,
-			icmpPeriod: t.ICMP.Period,
-			ports:      t.TCP.Range,
-			qps:        t.QueriesPerSecond,
+		target := &target{
+			ip:          t.IP,
+			host:        t.Host,
+			name:        t.Name,
+			tcpPeriod:   t.TCP.Period,
+			icmpPeriod:  t.ICMP.Period,
+			ports:       t.TCP.Range,
+			udpPorts:    t.UDP.Range,
+			udpPeriod:   t.UDP.Period,
+			qps:         t.QueriesPerSecond,
+			banners:     t.Banners || c.Banners,
+			httpPath:    t.HTTP.Path,
+			portLatency: c.PortLatencyMetrics,
 		}
 
 		// Set to global values if specific values are not set
@@ -84,6 +293,15 @@ func (s *Scanner) Start(c *config.Conf) error {
 		if target.tcpPeriod == "" {
 			target.tcpPeriod = c.TcpPeriod
 		}
+		if target.udpPeriod == "" {
+			target.udpPeriod = c.UdpPeriod
+		}
+		if c.PortStateMetrics {
+			target.portStateLimit = c.PortStateLimit
+			if target.portStateLimit == 0 {
+				target.portStateLimit = defaultPortStateLimit
+			}
+		}
 		if target.icmpPeriod == "" {
 			target.icmpPeriod = c.IcmpPeriod
 		}
@@ -118,24 +336,78 @@ func (s *Scanner) Start(c *config.Conf) error {
 		if !target.doPing {
 			s.Logger.Warn().Msgf("ping explicitly disabled for %s (%s) in configuration",
 				target.name,
-				target.ip)
+				target.address())
+		}
+
+		method, err := readMethod(t.TCP.Method)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TCP method for %s: %w", target.name, err)
 		}
+		target.tcpMethod = method
 
-		// Read target's expected port range
-		exp, err := readPortsRange(t.TCP.Expected)
+		// Read target's expected port range, and the services expected on
+		// them
+		exp, services, err := readExpected(t.TCP.Expected)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		target.services = services
 
 		// Append them to the target
 		for _, port := range exp {
 			target.expected = append(target.expected, strconv.Itoa(port))
 		}
 
-		// Inform that we can't parse the IP, and skip this target
-		if ok := net.ParseIP(target.ip); ok == nil {
-			s.Logger.Error().Msgf("cannot parse IP %s", target.ip)
-			continue
+		// Ports probed with HTTP requests
+		web, err := readPortsRange(t.HTTP.Ports)
+		if err != nil {
+			return nil, err
+		}
+		if len(web) > 0 || len(t.ExpectedHTTP) > 0 {
+			target.httpPorts = make(map[string]bool)
+			target.expectedHTTP = make(map[string]config.ExpectedHTTP)
+			if target.httpPath == "" {
+				target.httpPath = "/"
+			}
+		}
+		for _, port := range web {
+			target.httpPorts[strconv.Itoa(port)] = true
+		}
+		for _, e := range t.ExpectedHTTP {
+			target.httpPorts[strconv.Itoa(e.Port)] = true
+			target.expectedHTTP[strconv.Itoa(e.Port)] = e
+		}
+
+		// Same for UDP
+		exp, err = readPortsRange(t.UDP.Expected)
+		if err != nil {
+			return nil, err
+		}
+		for _, port := range exp {
+			target.udpExpected = append(target.udpExpected, strconv.Itoa(port))
+		}
+
+		// Expand CIDRs and ranges. Inform that we can't parse the IP, and skip
+		// this target. Host targets are resolved at each scan instead.
+		hosts := []string{""}
+		if target.host != "" {
+			if target.ip != "" {
+				s.Logger.Error().Msgf("%s has both an IP and a host, skipping it", target.name)
+				continue
+			}
+		} else {
+			maxHosts := c.MaxHosts
+			if maxHosts == 0 {
+				maxHosts = defaultMaxHosts
+			}
+			hosts, err = expandHosts(target.ip, t.Exclude, maxHosts)
+			if err != nil {
+				s.Logger.Error().Err(err).Msgf("cannot parse IP %s", target.ip)
+				continue
+			}
+			if len(hosts) > 1 {
+				s.Logger.Info().Msgf("%s (%s) expanded to %d hosts", target.name, target.ip, len(hosts))
+			}
 		}
 
 		// If TCP period or ports range has been provided, it means that we want
@@ -144,208 +416,523 @@ func (s *Scanner) Start(c *config.Conf) error {
 			target.doTCP = true
 		}
 
-		// Launch target's ping goroutine. It embeds its own ticker
-		if target.doPing {
-			go target.ping(s.Logger, time.Duration(c.Timeout)*time.Second, pchan)
+		// Unlike TCP, a global UDP period is not enough to enable UDP scans:
+		// ports must be given
+		if target.udpPorts != "" || len(target.udpExpected) != 0 {
+			target.doUDP = true
 		}
 
+		// Periods are checked now, so a reload with a bad value does not kill
+		// a running scanner
 		if target.doTCP {
-			s.Targets = append(s.Targets, target)
+			if _, err := getDuration(target.tcpPeriod); err != nil {
+				return nil, fmt.Errorf("invalid TCP period for %s: %w", target.name, err)
+			}
+		}
+		if target.doUDP {
+			if _, err := getDuration(target.udpPeriod); err != nil {
+				return nil, fmt.Errorf("invalid UDP period for %s: %w", target.name, err)
+			}
+		}
+		if target.doPing {
+			if _, err := getDuration(target.icmpPeriod); err != nil {
+				return nil, fmt.Errorf("invalid ICMP period for %s: %w", target.name, err)
+			}
+		}
+
+		for _, host := range hosts {
+			ht := target.withIP(host)
+			targets[ht.key()] = ht
 		}
 	}
 
-	trigger := make(chan string, len(s.Targets)*2)
+	return targets, nil
+}
 
-	// scanIsOver is used by s.run() to notify the receiver that all the ports
-	// have been scanned
-	scanIsOver := make(chan target, len(s.Targets))
+// apply replaces the running targets by the given ones. Targets that did not
+// change keep running untouched, changed ones are restarted and removed ones
+// are stopped and forgotten.
+func (s *Scanner) apply(targets map[string]*target) {
+	for key, old := range s.Targets {
+		t, ok := targets[key]
+		switch {
+		case !ok:
+			s.Logger.Info().Msgf("target %s (%s) removed", old.name, old.address())
+			old.cancel()
+			s.forget(old, "")
+		case !old.sameAs(t):
+			s.Logger.Info().Msgf("target %s (%s) changed, restarting it", old.name, old.address())
+			old.cancel()
+			if old.doPing && !t.doPing {
+				s.stopPing(old)
+			}
+			if old.doTCP && !t.doTCP {
+				s.forget(old, "tcp")
+			}
+			if old.doUDP && !t.doUDP {
+				s.forget(old, "udp")
+			}
+			// Keep the resolved addresses to detect changes on the next scan
+			t.addrs = old.addrs
+		default:
+			targets[key] = old
+		}
+	}
+
+	tcpTargets, udpTargets := 0, 0
+	for _, t := range targets {
+		if t.doTCP {
+			tcpTargets++
+		}
+		if t.doUDP {
+			udpTargets++
+		}
 
-	// singleResult is used by s.scanPort() to send an open port to the receiver.
-	// The format is ip:port
-	singleResult := make(chan string, c.Limit)
+		// Already running
+		if t.ctx != nil {
+			continue
+		}
+		t.ctx, t.cancel = context.WithCancel(context.Background())
+		t.pingMu = new(sync.Mutex)
 
-	s.Logger.Debug().Msgf("%d targets will be scanned using TCP", len(s.Targets))
+		// Launch target's ping goroutine. It embeds its own ticker
+		if t.doPing {
+			go t.ping(s.Logger, s.Timeout, s.pchan)
+		}
 
-	// Start scheduler for each target
-	for _, t := range s.Targets {
-		t := t
-		s.Logger.Debug().Msgf("start scheduler for %s", t.name)
-		go t.scheduler(s.Logger, trigger)
+		if t.doTCP {
+			s.Logger.Debug().Msgf("start TCP scheduler for %s", t.name)
+			t.scheduler(s.Logger, s.trigger, "tcp")
+		}
+		if t.doUDP {
+			s.Logger.Debug().Msgf("start UDP scheduler for %s", t.name)
+			t.scheduler(s.Logger, s.trigger, "udp")
+		}
 	}
+	s.Targets = targets
+	s.MetricsServ.NumOfTargets.Set(float64(len(targets)))
 
-	// Create channel for communication with metrics server
-	mchan := make(chan metrics.NewMetrics, len(s.Targets)*2)
+	s.Logger.Debug().Msgf("%d targets will be scanned using TCP", tcpTargets)
+	s.Logger.Debug().Msgf("%d targets will be scanned using UDP", udpTargets)
+}
 
-	// Channel that will hold the number of scans in the waiting line (len of
-	// the trigger chan)
-	pendingchan := make(chan int, len(s.Targets))
+// stopPing deletes the ping series of a target that is no longer pinged, for
+// each of its addresses.
+func (s *Scanner) stopPing(t *target) {
+	addrs := []string{t.ip}
+	if t.host != "" {
+		addrs = t.addrs
+	}
+	for _, addr := range addrs {
+		s.stopPingAddr(t, addr)
+	}
+}
 
-	// Goroutine that will send to metrics the number of pendings scan
-	go func() {
-		for {
-			time.Sleep(500 * time.Millisecond)
-			pendingchan <- len(trigger)
-		}
-	}()
+// stopPingAddr deletes the ping series of one of the addresses of a target.
+// They are deleted through the ping channel, after the results of the pings
+// that completed before the target was cancelled, so none of them brings the
+// series back.
+func (s *Scanner) stopPingAddr(t *target, addr string) {
+	if t.pingMu != nil {
+		t.pingMu.Lock()
+		defer t.pingMu.Unlock()
+	}
+	s.pchan <- metrics.PingInfo{Name: t.name, IP: addr, Stopped: true}
+}
 
-	// Start the metrics updater
-	go s.MetricsServ.Updater(mchan, pchan, pendingchan)
+// key identifies a target across configuration reloads.
+func (t *target) key() string {
+	return t.name + "/" + t.address()
+}
 
-	// Start the receiver
-	go receiver(scanIsOver, singleResult, pchan, mchan)
+// address returns the host of the target if it has one, its IP otherwise.
+func (t *target) address() string {
+	if t.host != "" {
+		return t.host
+	}
+	return t.ip
+}
 
-	// Wait for triggers, build the scanner and run it
-	for {
-		select {
-		case triggeredIP := <-trigger:
-			s.Logger.Debug().Msgf("starting new scan for %s", triggeredIP)
-			if err := s.run(triggeredIP, scanIsOver, singleResult); err != nil {
-				s.Logger.Error().Err(err).Msg("error running scan")
-			}
-		}
+// withIP returns a copy of the target bound to ip.
+func (t *target) withIP(ip string) *target {
+	c := *t
+	c.ip = ip
+	return &c
+}
+
+// sameAs reports whether two targets share the same settings.
+func (t *target) sameAs(o *target) bool {
+	return t.ip == o.ip &&
+		t.host == o.host &&
+		t.name == o.name &&
+		t.ports == o.ports &&
+		slices.Equal(t.expected, o.expected) &&
+		maps.Equal(t.services, o.services) &&
+		t.udpPorts == o.udpPorts &&
+		slices.Equal(t.udpExpected, o.udpExpected) &&
+		t.doTCP == o.doTCP &&
+		t.doUDP == o.doUDP &&
+		t.doPing == o.doPing &&
+		t.tcpPeriod == o.tcpPeriod &&
+		t.udpPeriod == o.udpPeriod &&
+		t.icmpPeriod == o.icmpPeriod &&
+		t.qps == o.qps &&
+		t.banners == o.banners &&
+		t.tcpMethod == o.tcpMethod &&
+		maps.Equal(t.httpPorts, o.httpPorts) &&
+		t.httpPath == o.httpPath &&
+		maps.Equal(t.expectedHTTP, o.expectedHTTP) &&
+		t.portStateLimit == o.portStateLimit &&
+		t.portLatency == o.portLatency
+}
+
+// settings returns the ports to scan, the expected open ports and the period
+// of a protocol.
+func (t *target) settings(proto string) (ports string, expected []string, period string) {
+	if proto == "udp" {
+		return t.udpPorts, t.udpExpected, t.udpPeriod
 	}
+	return t.ports, t.expected, t.tcpPeriod
 }
 
-func (s *Scanner) run(ip string, scanIsOver chan target, singleResult chan string) error {
-	for _, t := range s.Targets {
-		// Find which target to scan
-		if t.ip == ip {
-			wg := sync.WaitGroup{}
+// run scans all the ports of a target. Targets defined by a host name are
+// resolved first, and each of their addresses is scanned. It returns the
+// number of scanned addresses.
+func (s *Scanner) run(j job, scanIsOver chan job, singleResult chan result) (int, error) {
+	t := j.t
+	portsRange, _, _ := t.settings(j.proto)
+	ports, err := readPortsRange(portsRange)
+	if err != nil {
+		return 0, err
+	}
 
-			ports, err := readPortsRange(t.ports)
-			if err != nil {
-				return err
-			}
+	addrs, err := s.resolve(t)
+	if err != nil {
+		return 0, err
+	}
 
-			// Configure sleeping time for rate limiting
-			var sleepingTime time.Duration
-			if t.qps > 1000000 || t.qps <= 0 {
-				// We want to wait less than a microsecond between each port scanning
-				// so, we do not wait at all.
-				// From time.Sleep documentation:
-				// A negative or zero duration causes Sleep to return immediately
-				sleepingTime = -1
-				t.qps = 0
-			} else {
-				sleepingTime = time.Second / time.Duration(t.qps)
-			}
+	// scanPort returns what has been learned about the port if it has been
+	// probed. Open TCP ports are probed when banners are enabled, when a
+	// service is expected on them, or when they are web ports, which are then
+	// requested over HTTP. The other open TCP ports only get a TLS handshake,
+	// so the certificates of all the TLS ports are inspected. SYN scans are
+	// used when the raw sockets they need are available, connect scans
+	// otherwise.
+	scanPort := func(ip string, port int) (portInfo, bool) {
+		p := strconv.Itoa(port)
+		web := t.httpPorts[p]
+		grab := t.banners || t.services[p] != "" || web
+		var info portInfo
+		var ok bool
+		if s.tcpMethod(t, ip) == MethodSYN {
+			info, ok = s.synScanPort(t, ip, port, grab, singleResult)
+		} else {
+			info, ok = s.scanPort(t, ip, port, grab, singleResult)
+		}
+		if ok && web {
+			info.http = s.probeHTTP(t, ip, port, info.service)
+		}
+		return info, ok
+	}
+	if j.proto == "udp" {
+		scanPort = func(ip string, port int) (portInfo, bool) {
+			s.scanUDPPort(t, ip, port, singleResult)
+			return portInfo{}, false
+		}
+	}
 
-			for _, p := range ports {
-				wg.Add(1)
-				s.Lock.Acquire(context.TODO(), 1)
-				go func(port int) {
-					defer s.Lock.Release(1)
-					defer wg.Done()
-					s.scanPort(ip, port, singleResult)
-				}(p)
-				time.Sleep(sleepingTime)
+	// Configure sleeping time for rate limiting
+	var sleepingTime time.Duration
+	if t.qps > 1000000 || t.qps <= 0 {
+		// We want to wait less than a microsecond between each port scanning
+		// so, we do not wait at all.
+		// From time.Sleep documentation:
+		// A negative or zero duration causes Sleep to return immediately
+		sleepingTime = -1
+	} else {
+		sleepingTime = time.Second / time.Duration(t.qps)
+	}
+
+	for _, addr := range addrs {
+		start := time.Now()
+		s.MetricsServ.LastScanStart.WithLabelValues(t.name, addr, j.proto).Set(float64(start.Unix()))
+		s.MetricsServ.ScanSize.WithLabelValues(t.name, addr, j.proto).Set(float64(len(ports)))
+		progress := s.MetricsServ.ScanProgress.WithLabelValues(t.name, addr, j.proto)
+		progress.Set(0)
+
+		// Targets asking for SYN scans tell whether they fell back to connect
+		// scans
+		var method string
+		if j.proto == "tcp" {
+			method = s.tcpMethod(t, addr)
+			if t.tcpMethod == MethodSYN {
+				fallback := 0.0
+				if method != MethodSYN {
+					fallback = 1
+				}
+				s.MetricsServ.SYNFallback.WithLabelValues(t.name, addr).Set(fallback)
+			} else {
+				s.MetricsServ.SYNFallback.DeleteLabelValues(t.name, addr)
 			}
-			wg.Wait()
+		}
 
-			// Inform the receiver that the scan for the target is over
-			scanIsOver <- t
-			return nil
+		var mu sync.Mutex
+		probed := make(probes)
+
+		wg := sync.WaitGroup{}
+		for _, p := range ports {
+			wg.Add(1)
+			s.Lock.Acquire(context.TODO(), 1)
+			go func(port int) {
+				defer s.Lock.Release(1)
+				defer wg.Done()
+				if info, ok := scanPort(addr, port); ok {
+					mu.Lock()
+					probed[strconv.Itoa(port)] = info
+					mu.Unlock()
+				}
+				progress.Inc()
+			}(p)
+			time.Sleep(sleepingTime)
 		}
+		wg.Wait()
+
+		s.MetricsServ.ScanDuration.WithLabelValues(t.name, addr, j.proto).Observe(time.Since(start).Seconds())
+		s.MetricsServ.LastScanSuccess.WithLabelValues(t.name, addr, j.proto).SetToCurrentTime()
+		s.Logger.Debug().Str("name", t.name).Str("ip", addr).Str("proto", j.proto).
+			Msgf("%s scan of %s (%s) took %s", j.proto, t.name, addr, time.Since(start))
+
+		// Inform the receiver that the scan for the address is over
+		scanIsOver <- job{t: t.withIP(addr), proto: j.proto, start: start, method: method, probes: probed}
 	}
-	return fmt.Errorf("IP to scan not found: %s", ip)
+	return len(addrs), nil
 }
 
-// scanPort scans a single port and sends the result through singleResult.
-// There is 2 formats: when a port is open, it sends `ip:port:OK`, and when it is
-// closed, it sends `ip:port:NOP`
-func (s *Scanner) scanPort(ip string, port int, singleResult chan string) {
-	p := strconv.Itoa(port)
-	target := ip + ":" + p
+// scanPort scans a single TCP port of an IP of t and sends the result through
+// singleResult. The port is open when the connection succeeds, closed when it
+// is refused, filtered when it times out and error otherwise. If grab is set,
+// an open port is probed and what has been learned about it is returned.
+// Otherwise, it is only tried with a TLS handshake, see probeTLS. The host of
+// t is sent in TLS handshakes, if set.
+func (s *Scanner) scanPort(t *target, ip string, port int, grab bool, singleResult chan result) (portInfo, bool) {
+	target := net.JoinHostPort(ip, strconv.Itoa(port))
+	start := time.Now()
 	conn, err := net.DialTimeout("tcp", target, s.Timeout)
 	if err != nil {
 		// If the error contains the message "too many open files", wait a little
 		// and retry
 		if strings.Contains(err.Error(), "too many open files") {
 			time.Sleep(s.Timeout)
-			s.scanPort(ip, port, singleResult)
+			return s.scanPort(t, ip, port, grab, singleResult)
 		}
-		// The result follows the format ip:port:NOP
-		singleResult <- ip + ":" + p + ":NOP"
-		return
+		state, reason := dialState(err)
+		if state == metrics.StateError {
+			s.Logger.Debug().Err(err).Msgf("error scanning %s", target)
+		}
+		singleResult <- newResult(t.name, ip, port, "tcp", state, reason, start)
+		return portInfo{}, false
 	}
-	conn.Close()
+	defer conn.Close()
+
+	// The port is known to be open, so the result is not delayed by the probe
+	singleResult <- newResult(t.name, ip, port, "tcp", metrics.StateOpen, "", start)
 
-	// The result follows the format ip:port:OK
-	singleResult <- ip + ":" + p + ":OK"
+	if !grab {
+		conn.Close()
+		return s.probeTLS(target, t.host)
+	}
+	return s.probe(conn, target, t.host), true
 }
 
-// scheduler create tickers for each protocol given and when they tick,
-// it sends the protocol name in the trigger's channel in order to alert
-// feeder that a scan must be started.
-func (t *target) scheduler(logger zerolog.Logger, trigger chan string) {
+// scheduler create a ticker for the given protocol and when it ticks,
+// it sends the target in the trigger's channel in order to alert
+// feeder that a scan must be started. It stops when the target's context is
+// cancelled.
+func (t *target) scheduler(logger zerolog.Logger, trigger chan job, proto string) {
 	var ticker *time.Ticker
-	tcpFreq, err := getDuration(t.tcpPeriod)
+	_, _, period := t.settings(proto)
+	freq, err := getDuration(period)
 	if err != nil {
-		logger.Error().Msgf("error getting TCP frequency for %s scheduler: %s", t.name, err)
+		logger.Error().Msgf("error getting %s frequency for %s scheduler: %s", proto, t.name, err)
 	}
-	ticker = time.NewTicker(tcpFreq)
+	ticker = time.NewTicker(freq)
+
+	j := job{t: t, proto: proto}
 
 	// starts its own ticker
-	go func(trigger chan string, ticker *time.Ticker, ip string) {
+	go func(trigger chan job, ticker *time.Ticker) {
+		defer ticker.Stop()
+
 		// Start scan at launch
-		trigger <- t.ip
+		select {
+		case trigger <- j:
+		case <-t.ctx.Done():
+			return
+		}
 		for {
 			select {
 			case <-ticker.C:
-				trigger <- t.ip
+				select {
+				case trigger <- j:
+				case <-t.ctx.Done():
+					return
+				}
+			case <-t.ctx.Done():
+				return
 			}
 		}
-	}(trigger, ticker, t.ip)
+	}(trigger, ticker)
 }
 
-func receiver(scanIsOver chan target, singleResult chan string, pchan chan metrics.PingInfo, mchan chan metrics.NewMetrics) {
-	// openPorts holds the ports that are open for each target
-	openPorts := make(map[string][]string)
-	// closedPorts holds the ports that are closed
-	closedPorts := make(map[string][]string)
+func receiver(scanIsOver chan job, singleResult chan result, mchan chan metrics.NewMetrics, store storage.Backend, history *storage.History, sinks []sink.Sink) {
+	// results holds the scanned ports of each target and protocol
+	results := make(map[string][]result)
+	// banners holds the last banners of each target and protocol, by port
+	banners := make(map[string]map[string]string)
 
-	// Create the store for the values
-	store := storage.Create()
+	record := func(res result) {
+		results[res.key()] = append(results[res.key()], res)
+	}
 
 	for {
 		select {
-		case t := <-scanIsOver:
-			// Compare stored results with current results and get the delta
-			delta := common.CompareStringSlices(store.Get(t.ip), openPorts[t.ip])
+		case j := <-scanIsOver:
+			// All the results of the scan have been sent before it is over,
+			// but some can still be buffered
+			for drained := false; !drained; {
+				select {
+				case res := <-singleResult:
+					record(res)
+				default:
+					drained = true
+				}
+			}
+
+			t := j.t
+			if j.removed {
+				// Forget everything about the target, or only about the
+				// protocol it is not scanned with anymore. The removal goes
+				// through the same channels as the scans and the metrics, so
+				// it can't be overtaken by a late update.
+				protos := []string{"tcp", "udp"}
+				if j.proto != "" {
+					protos = []string{j.proto}
+				}
+				for _, proto := range protos {
+					key := resultKey(t.name, t.ip, proto)
+					store.Delete(key)
+					delete(results, key)
+					delete(banners, key)
+				}
+				if history != nil {
+					history.Delete(t.name, t.ip, j.proto)
+				}
+
+				mchan <- metrics.NewMetrics{
+					Name:    t.name,
+					IP:      t.ip,
+					Proto:   j.proto,
+					Removed: true,
+				}
+				continue
+			}
+
+			key := resultKey(t.name, t.ip, j.proto)
+			_, expected, _ := t.settings(j.proto)
+			ports, errs := byState(results[key])
+
+			// Compare stored results with current results and get the delta.
+			// There is no delta without a baseline, on the first scan since
+			// the start when nothing is persisted, or since the target was
+			// added.
+			previous := store.Get(key)
+			var opened, closed []string
+			if previous != nil {
+				opened, closed = common.DiffStringSlices(previous, ports[metrics.StateOpen])
+			}
+
+			// A changed banner can mean another service took the port
+			current, services := j.probes.banners(), j.probes.services()
+			for port, banner := range current {
+				if old, ok := banners[key][port]; ok && old != banner {
+					log.Warn().Str("name", t.name).Str("ip", t.ip).Str("port", port).Str("old", old).Str("new", banner).
+						Msgf("banner of %s (%s) port %s/%s changed", t.name, t.ip, port, j.proto)
+				}
+			}
+			if len(current) > 0 {
+				banners[key] = current
+			} else {
+				delete(banners, key)
+			}
+
+			// Services are only expected on TCP ports, and only TCP ports are
+			// connected to
+			var expectedServices map[string]string
+			var latencies map[string]time.Duration
+			if j.proto == "tcp" {
+				expectedServices = t.services
+				latencies = openLatencies(results[key])
+			}
+
+			if history != nil {
+				history.Add(t.name, storage.Entry{
+					Time:     time.Now(),
+					IP:       t.ip,
+					Proto:    j.proto,
+					Open:     append([]string{}, ports[metrics.StateOpen]...),
+					Opened:   opened,
+					Closed:   closed,
+					Banners:  current,
+					Services: services,
+				})
+			}
+
+			if len(sinks) > 0 {
+				r := sink.NewResult(t.name, t.ip, j.proto, j.method, j.start, sinkPorts(results[key], expected), j.probes.sink())
+				for _, sk := range sinks {
+					if err := sk.Write(r); err != nil {
+						log.Error().Err(err).Str("name", t.name).Str("ip", t.ip).Msgf("cannot export %s scan of %s (%s)", j.proto, t.name, t.ip)
+					}
+				}
+			}
 
 			// Update metrics
 			updatedMetrics := metrics.NewMetrics{
-				Name:     t.name,
-				IP:       t.ip,
-				Diff:     delta,
-				Open:     openPorts[t.ip],
-				Closed:   closedPorts[t.ip],
-				Expected: t.expected,
+				Name:             t.name,
+				IP:               t.ip,
+				Proto:            j.proto,
+				Previous:         previous,
+				Opened:           opened,
+				Closed:           closed,
+				Ports:            ports,
+				Errors:           errs,
+				Expected:         expected,
+				Services:         services,
+				ExpectedServices: expectedServices,
+				TLS:              j.probes.tls(),
+				HTTP:             j.probes.http(),
+				Latencies:        latencies,
+				PortStateLimit:   t.portStateLimit,
+				PortLatency:      t.portLatency,
 			}
 
 			// Send new metrics
 			mchan <- updatedMetrics
 
-			// Update the store
-			store.Update(t.ip, openPorts[t.ip])
+			// Update the store. An empty list, unlike nil, is the baseline of a
+			// scan that found no open port.
+			store.Update(key, append([]string{}, ports[metrics.StateOpen]...))
 
-			// Clear slices
-			openPorts[t.ip] = nil
-			closedPorts[t.ip] = nil
+			// Clear results
+			delete(results, key)
 		case res := <-singleResult:
-			split := strings.Split(res, ":")
-			// Useless allocations, but it's easier to read
-			ip := string(split[0])
-			port := string(split[1])
-			status := string(split[2])
-
-			if status == "OK" {
-				openPorts[ip] = append(openPorts[ip], port)
-			} else if status == "NOP" {
-				closedPorts[ip] = append(closedPorts[ip], port)
-			} else {
-				log.Fatal().Msgf("port status not recognised: %s (%s)", status, ip)
-			}
+			record(res)
 		}
 	}
 }
+
+// resultKey is the key under which the results of a protocol scan of an IP of
+// a target are kept.
+func resultKey(name, ip, proto string) string {
+	return name + "/" + proto + "/" + ip
+}
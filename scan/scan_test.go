@@ -0,0 +1,315 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/devops-works/scan-exporter/metrics"
+	"github.com/devops-works/scan-exporter/notifier"
+	"github.com/devops-works/scan-exporter/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// testServer returns the metrics server of the tests. It is only created once,
+// as its series can only be registered once.
+var testServer = sync.OnceValue(func() *metrics.Server { return metrics.Init("") })
+
+func Test_target_sameAs(t *testing.T) {
+	base := target{
+		ip:         "198.51.100.42",
+		name:       "app1",
+		ports:      "reserved",
+		expected:   []string{"22", "80"},
+		doTCP:      true,
+		doPing:     true,
+		tcpPeriod:  "12h",
+		icmpPeriod: "1m",
+		qps:        500,
+	}
+
+	tests := []struct {
+		name   string
+		change func(t *target)
+		want   bool
+	}{
+		{name: "identical", change: func(t *target) {}, want: true},
+		{name: "different range", change: func(t *target) { t.ports = "all" }, want: false},
+		{name: "different expected", change: func(t *target) { t.expected = []string{"22"} }, want: false},
+		{name: "different tcp period", change: func(t *target) { t.tcpPeriod = "6h" }, want: false},
+		{name: "ping disabled", change: func(t *target) { t.doPing = false }, want: false},
+		{name: "different qps", change: func(t *target) { t.qps = 1000 }, want: false},
+		{name: "banners enabled", change: func(t *target) { t.banners = true }, want: false},
+		{name: "expected service", change: func(t *target) { t.services = map[string]string{"22": "ssh"} }, want: false},
+		{name: "syn method", change: func(t *target) { t.tcpMethod = MethodSYN }, want: false},
+		{name: "port latency metrics", change: func(t *target) { t.portLatency = true }, want: false},
+		{name: "http path", change: func(t *target) { t.httpPath = "/health" }, want: false},
+		{name: "expected http", change: func(t *target) {
+			t.expectedHTTP = map[string]config.ExpectedHTTP{"80": {Port: 80, Status: 301}}
+		}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := base
+			other.expected = append([]string{}, base.expected...)
+			tt.change(&other)
+			if got := base.sameAs(&other); got != tt.want {
+				t.Errorf("sameAs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dialState(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		want       string
+		wantReason string
+	}{
+		{name: "refused", err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, want: metrics.StateClosed, wantReason: metrics.ReasonRefused},
+		{name: "timeout", err: &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, want: metrics.StateFiltered, wantReason: metrics.ReasonTimeout},
+		{name: "host unreachable", err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}, want: metrics.StateError, wantReason: metrics.ReasonHostUnreachable},
+		{name: "network unreachable", err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}, want: metrics.StateError, wantReason: metrics.ReasonNetworkUnreachable},
+		{name: "permission", err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EPERM)}, want: metrics.StateError, wantReason: metrics.ReasonPermission},
+		{name: "other", err: errors.New("boom"), want: metrics.StateError, wantReason: metrics.ReasonOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, reason := dialState(tt.err); got != tt.want || reason != tt.wantReason {
+				t.Errorf("dialState() = %v, %v, want %v, %v", got, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestScanner_scanPort(t *testing.T) {
+	open, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+
+	// Bind then release a port, so it is most likely closed
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		port       int
+		want       string
+		wantReason string
+	}{
+		{name: "open", port: open.Addr().(*net.TCPAddr).Port, want: metrics.StateOpen},
+		{name: "closed", port: closedPort, want: metrics.StateClosed, wantReason: metrics.ReasonRefused},
+	}
+	s := Scanner{Timeout: time.Second}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := make(chan result, 1)
+			before := time.Now()
+			s.scanPort(&target{name: "app1"}, "127.0.0.1", tt.port, false, res)
+			got := <-res
+			if got.name != "app1" || got.ip != "127.0.0.1" || got.port != tt.port || got.proto != "tcp" || got.state != tt.want || got.reason != tt.wantReason {
+				t.Errorf("scanPort() = %+v, want %s with reason %q", got, tt.want, tt.wantReason)
+			}
+			if got.latency <= 0 || got.time.Before(before) {
+				t.Errorf("scanPort() latency = %v, time = %v, want them measured", got.latency, got.time)
+			}
+		})
+	}
+}
+
+func TestScanner_apply(t *testing.T) {
+	ipTarget := target{ip: "198.51.100.42", name: "app1", doPing: true, icmpPeriod: "1h", qps: 500, doTCP: true, tcpPeriod: "1h", doUDP: true, udpPeriod: "1h"}
+	hostTarget := target{host: "app1.example.com", name: "app1", doPing: true, icmpPeriod: "1h", addrs: []string{"198.51.100.42", "2001:db8::42"}}
+
+	tests := []struct {
+		name   string
+		old    target
+		change func(t *target)
+		want   []metrics.PingInfo
+		// wantForgotten lists the protocols forgotten by the receiver
+		wantForgotten []string
+	}{
+		{name: "unchanged", old: ipTarget, change: func(t *target) {}},
+		{name: "ping still enabled", old: ipTarget, change: func(t *target) { t.qps = 1000 }},
+		{
+			name:   "ping disabled",
+			old:    ipTarget,
+			change: func(t *target) { t.doPing = false },
+			want:   []metrics.PingInfo{{Name: "app1", IP: "198.51.100.42", Stopped: true}},
+		},
+		{
+			name:   "host ping disabled",
+			old:    hostTarget,
+			change: func(t *target) { t.doPing = false },
+			want: []metrics.PingInfo{
+				{Name: "app1", IP: "198.51.100.42", Stopped: true},
+				{Name: "app1", IP: "2001:db8::42", Stopped: true},
+			},
+		},
+		{name: "udp disabled", old: ipTarget, change: func(t *target) { t.doUDP = false }, wantForgotten: []string{"udp"}},
+		{
+			name:          "tcp disabled",
+			old:           ipTarget,
+			change:        func(t *target) { t.doTCP, t.doPing = false, false },
+			want:          []metrics.PingInfo{{Name: "app1", IP: "198.51.100.42", Stopped: true}},
+			wantForgotten: []string{"tcp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := tt.old.withIP(tt.old.ip)
+			old.ctx, old.cancel = context.WithCancel(context.Background())
+			defer old.cancel()
+			updated := tt.old.withIP(tt.old.ip)
+			tt.change(updated)
+
+			s := Scanner{
+				Targets:     map[string]*target{old.key(): old},
+				MetricsServ: metrics.Server{NumOfTargets: prometheus.NewGauge(prometheus.GaugeOpts{Name: "targets"})},
+				pchan:       make(chan metrics.PingInfo, 2),
+				trigger:     make(chan job, 2),
+				scanIsOver:  make(chan job, 2),
+			}
+			s.apply(map[string]*target{updated.key(): updated})
+			defer s.Targets[updated.key()].cancel()
+
+			var got []metrics.PingInfo
+			for len(s.pchan) > 0 {
+				got = append(got, <-s.pchan)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("apply() sent %+v, want %+v", got, tt.want)
+			}
+			var forgotten []string
+			for len(s.scanIsOver) > 0 {
+				if j := <-s.scanIsOver; j.removed && j.t.ip == old.ip {
+					forgotten = append(forgotten, j.proto)
+				}
+			}
+			if !slices.Equal(forgotten, tt.wantForgotten) {
+				t.Errorf("apply() forgot %v, want %v", forgotten, tt.wantForgotten)
+			}
+		})
+	}
+}
+
+func Test_receiver_baseline(t *testing.T) {
+	bodies := make(chan string, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies <- string(b)
+	}))
+	defer hook.Close()
+	webhooks, err := notifier.NewWebhooks(config.Notifications{
+		Webhooks: []config.Webhook{{URL: hook.URL, Template: `{{ .Name }} opened {{ join .Opened "," }} closed {{ join .Closed "," }}`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := *testServer()
+	srv.Notifiers = []notifier.Notifier{webhooks}
+	scanIsOver, singleResult := make(chan job), make(chan result, 10)
+	mchan := make(chan metrics.NewMetrics)
+	go receiver(scanIsOver, singleResult, mchan, storage.Create(), nil, nil)
+	go srv.Updater(mchan, make(chan metrics.PingInfo), make(chan int))
+
+	// The first scan has no baseline, so its open ports did not open. Once
+	// it is stored, the next scan is compared to it.
+	tgt := &target{name: "baseline", ip: "198.51.100.42"}
+	scan := func(open ...int) {
+		for _, port := range open {
+			singleResult <- newResult(tgt.name, tgt.ip, port, "tcp", metrics.StateOpen, "", time.Now())
+		}
+		scanIsOver <- job{t: tgt, proto: "tcp", start: time.Now()}
+	}
+	scan(22, 443)
+	scan(22, 80)
+
+	select {
+	case got := <-bodies:
+		if want := "baseline opened 80 closed 443"; got != want {
+			t.Errorf("first webhook = %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook sent for the second scan")
+	}
+}
+
+func Test_receiver_sharedIP(t *testing.T) {
+	scanIsOver, singleResult := make(chan job), make(chan result, 10)
+	mchan := make(chan metrics.NewMetrics, 2)
+	go receiver(scanIsOver, singleResult, mchan, storage.Create(), nil, nil)
+
+	// Two targets sharing an IP are scanned at the same time, and their
+	// results are interleaved
+	app1 := &target{name: "app1", ip: "198.51.100.42"}
+	app2 := &target{name: "app2", ip: "198.51.100.42"}
+	singleResult <- newResult(app1.name, app1.ip, 22, "tcp", metrics.StateOpen, "", time.Now())
+	singleResult <- newResult(app2.name, app2.ip, 80, "tcp", metrics.StateOpen, "", time.Now())
+	singleResult <- newResult(app1.name, app1.ip, 443, "tcp", metrics.StateClosed, metrics.ReasonRefused, time.Now())
+	scanIsOver <- job{t: app1, proto: "tcp", start: time.Now()}
+	scanIsOver <- job{t: app2, proto: "tcp", start: time.Now()}
+
+	want := map[string]map[string][]string{
+		"app1": {metrics.StateOpen: {"22"}, metrics.StateClosed: {"443"}},
+		"app2": {metrics.StateOpen: {"80"}},
+	}
+	for range want {
+		select {
+		case nm := <-mchan:
+			if !reflect.DeepEqual(nm.Ports, want[nm.Name]) {
+				t.Errorf("ports of %s = %v, want %v", nm.Name, nm.Ports, want[nm.Name])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no metrics sent for the scan")
+		}
+	}
+}
+
+func Test_receiver_removed(t *testing.T) {
+	scanIsOver, singleResult := make(chan job, 2), make(chan result, 10)
+	mchan := make(chan metrics.NewMetrics, 2)
+	store := storage.Create()
+
+	// The end of a scan is still queued when the target is removed
+	tgt := &target{name: "removed", ip: "198.51.100.42"}
+	singleResult <- newResult(tgt.name, tgt.ip, 22, "tcp", metrics.StateOpen, "", time.Now())
+	scanIsOver <- job{t: tgt, proto: "tcp", start: time.Now()}
+	scanIsOver <- job{t: tgt, removed: true}
+	go receiver(scanIsOver, singleResult, mchan, store, nil, nil)
+
+	var got []bool
+	for range 2 {
+		select {
+		case nm := <-mchan:
+			got = append(got, nm.Removed)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no metrics sent")
+		}
+	}
+	if !slices.Equal(got, []bool{false, true}) {
+		t.Errorf("got metrics removed %v, want the scan then the removal", got)
+	}
+	if ports := store.Get(resultKey(tgt.name, tgt.ip, "tcp")); ports != nil {
+		t.Errorf("store kept %v for the removed target", ports)
+	}
+}
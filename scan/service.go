@@ -0,0 +1,82 @@
+package scan
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Services identified on open TCP ports.
+const (
+	ServiceFTP     = "ftp"
+	ServiceHTTP    = "http"
+	ServiceIMAP    = "imap"
+	ServiceMySQL   = "mysql"
+	ServicePOP3    = "pop3"
+	ServiceRedis   = "redis"
+	ServiceSMTP    = "smtp"
+	ServiceSSH     = "ssh"
+	ServiceTLS     = "tls"
+	ServiceVNC     = "vnc"
+	ServiceUnknown = "unknown"
+)
+
+// Services lists the services that can be expected on a port.
+var Services = []string{
+	ServiceFTP, ServiceHTTP, ServiceIMAP, ServiceMySQL, ServicePOP3, ServiceRedis, ServiceSMTP, ServiceSSH, ServiceTLS, ServiceVNC,
+}
+
+// httpsErrors are answers of HTTPS servers to plain HTTP requests.
+var httpsErrors = [][]byte{
+	// Go
+	[]byte("HTTP request to an HTTPS server"),
+	// nginx
+	[]byte("plain HTTP request was sent to HTTPS port"),
+	// Apache
+	[]byte("speaking plain HTTP to an SSL-enabled server port"),
+}
+
+// greetingService identifies a service from the first bytes it sent, before
+// the client said anything.
+func greetingService(b []byte) string {
+	s := string(b)
+	switch {
+	case strings.HasPrefix(s, "SSH-"):
+		return ServiceSSH
+	case strings.HasPrefix(s, "220"):
+		// FTP and SMTP servers both greet with 220
+		if strings.Contains(strings.ToUpper(s), "FTP") {
+			return ServiceFTP
+		}
+		return ServiceSMTP
+	case strings.HasPrefix(s, "+OK"):
+		return ServicePOP3
+	case strings.HasPrefix(s, "* OK"), strings.HasPrefix(s, "* PREAUTH"):
+		return ServiceIMAP
+	case strings.HasPrefix(s, "RFB "):
+		return ServiceVNC
+	case len(b) > 4 && b[3] == 0 && (b[4] == 0x0a || b[4] == 0xff):
+		// MySQL packet with sequence 0, holding the protocol 10 handshake or
+		// an error, like a host not allowed to connect
+		return ServiceMySQL
+	}
+	return ServiceUnknown
+}
+
+// answerService identifies a service from its answer to bannerNudge.
+func answerService(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte("HTTP/")):
+		for _, msg := range httpsErrors {
+			if bytes.Contains(b, msg) {
+				return ServiceTLS
+			}
+		}
+		return ServiceHTTP
+	case bytes.HasPrefix(b, []byte("-ERR")), bytes.HasPrefix(b, []byte("-NOAUTH")), bytes.HasPrefix(b, []byte("-DENIED")):
+		return ServiceRedis
+	case len(b) > 1 && (b[0] == 0x15 || b[0] == 0x16) && b[1] == 0x03:
+		// TLS alert or handshake record
+		return ServiceTLS
+	}
+	return greetingService(b)
+}
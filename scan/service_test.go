@@ -0,0 +1,51 @@
+package scan
+
+import "testing"
+
+func Test_greetingService(t *testing.T) {
+	tests := []struct {
+		name     string
+		greeting string
+		want     string
+	}{
+		{name: "ssh", greeting: "SSH-2.0-OpenSSH_9.6\r\n", want: ServiceSSH},
+		{name: "smtp", greeting: "220 mail.example.com ESMTP Postfix\r\n", want: ServiceSMTP},
+		{name: "ftp", greeting: "220 (vsFTPd 3.0.5)\r\n", want: ServiceFTP},
+		{name: "pop3", greeting: "+OK Dovecot ready.\r\n", want: ServicePOP3},
+		{name: "imap", greeting: "* OK [CAPABILITY IMAP4rev1] Dovecot ready.\r\n", want: ServiceIMAP},
+		{name: "vnc", greeting: "RFB 003.008\n", want: ServiceVNC},
+		{name: "mysql", greeting: "J\x00\x00\x00\x0a8.0.36\x00", want: ServiceMySQL},
+		{name: "mysql error", greeting: "E\x00\x00\x00\xffj\x04Host '10.0.0.2' is not allowed", want: ServiceMySQL},
+		{name: "unknown", greeting: "hello\r\n", want: ServiceUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := greetingService([]byte(tt.greeting)); got != tt.want {
+				t.Errorf("greetingService() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_answerService(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		want   string
+	}{
+		{name: "http", answer: "HTTP/1.1 301 Moved Permanently\r\nLocation: https://example.com/\r\n", want: ServiceHTTP},
+		{name: "https", answer: "HTTP/1.0 400 Bad Request\r\n\r\nClient sent an HTTP request to an HTTPS server.\n", want: ServiceTLS},
+		{name: "redis", answer: "-ERR unknown command 'HEAD'\r\n", want: ServiceRedis},
+		{name: "redis with auth", answer: "-NOAUTH Authentication required.\r\n", want: ServiceRedis},
+		{name: "tls alert", answer: "\x15\x03\x01\x00\x02\x02\x46", want: ServiceTLS},
+		{name: "late greeting", answer: "SSH-2.0-dropbear\r\n", want: ServiceSSH},
+		{name: "unknown", answer: "?\r\n", want: ServiceUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := answerService([]byte(tt.answer)); got != tt.want {
+				t.Errorf("answerService() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
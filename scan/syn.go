@@ -0,0 +1,251 @@
+package scan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/devops-works/scan-exporter/metrics"
+)
+
+// TCP scan methods. Connect scans complete the TCP handshake, SYN scans only
+// send the first packet and read the answer from a raw socket.
+const (
+	MethodConnect = "connect"
+	MethodSYN     = "syn"
+)
+
+// TCP flags used by SYN scans.
+const (
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpACK = 0x10
+)
+
+// synHeaderLen is the length of the TCP header of a SYN, with its MSS option.
+const synHeaderLen = 24
+
+// readMethod checks a TCP scan method. An empty method is a connect scan.
+func readMethod(method string) (string, error) {
+	switch method {
+	case "", MethodConnect:
+		return MethodConnect, nil
+	case MethodSYN:
+		return MethodSYN, nil
+	default:
+		return "", fmt.Errorf("unknown TCP method %q, must be %s or %s", method, MethodConnect, MethodSYN)
+	}
+}
+
+// tcpMethod returns how the TCP ports of an IP of t are scanned: with SYNs
+// when t asks for them and the raw socket they need is available, with
+// connect scans otherwise.
+func (s *Scanner) tcpMethod(t *target, ip string) string {
+	if t.tcpMethod == MethodSYN && s.syn.supports(ip) {
+		return MethodSYN
+	}
+	return MethodConnect
+}
+
+// synKey identifies the answer to a SYN: the address and port it was sent
+// to, and the local port it was sent from.
+type synKey struct {
+	addr        netip.Addr
+	port, local uint16
+}
+
+// segment is the part of a TCP header read from the answers to SYNs.
+type segment struct {
+	srcPort, dstPort uint16
+	ack              uint32
+	flags            byte
+}
+
+// parseSegment reads the ports, acknowledgment number and flags of a TCP
+// header.
+func parseSegment(b []byte) (segment, bool) {
+	if len(b) < 20 {
+		return segment{}, false
+	}
+	return segment{
+		srcPort: binary.BigEndian.Uint16(b[0:2]),
+		dstPort: binary.BigEndian.Uint16(b[2:4]),
+		ack:     binary.BigEndian.Uint32(b[8:12]),
+		flags:   b[13],
+	}, true
+}
+
+// synPacket builds the TCP header of a SYN from src to dst, with an MSS
+// option like the ones sent by operating systems, and its checksum.
+func synPacket(src, dst netip.AddrPort, seq uint32) []byte {
+	b := make([]byte, synHeaderLen)
+	binary.BigEndian.PutUint16(b[0:2], src.Port())
+	binary.BigEndian.PutUint16(b[2:4], dst.Port())
+	binary.BigEndian.PutUint32(b[4:8], seq)
+	b[12] = synHeaderLen / 4 << 4
+	b[13] = tcpSYN
+	binary.BigEndian.PutUint16(b[14:16], 1024)
+	// MSS of 1460 bytes
+	copy(b[20:], []byte{2, 4, 0x05, 0xb4})
+	binary.BigEndian.PutUint16(b[16:18], tcpChecksum(src.Addr(), dst.Addr(), b))
+	return b
+}
+
+// tcpChecksum computes the checksum of a TCP segment sent from src to dst,
+// which covers a pseudo-header made of the addresses, the protocol and the
+// length of the segment.
+func tcpChecksum(src, dst netip.Addr, seg []byte) uint16 {
+	pseudo := append(src.AsSlice(), dst.AsSlice()...)
+	if src.Is4() {
+		pseudo = append(pseudo, 0, 6)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(seg)))
+	} else {
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(seg)))
+		pseudo = append(pseudo, 0, 0, 0, 6)
+	}
+
+	var sum uint32
+	for _, b := range [][]byte{pseudo, seg} {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// unreachable is an ICMP or ICMPv6 destination unreachable message about a
+// SYN: the key and sequence number of the SYN, and the state of its port.
+type unreachable struct {
+	key           synKey
+	seq           uint32
+	state, reason string
+}
+
+// parseUnreachable reads a destination unreachable message about a TCP
+// segment, starting at its ICMP or ICMPv6 header. It quotes the IP header and
+// the first 8 bytes of the segment.
+func parseUnreachable(b []byte, v6 bool) (unreachable, bool) {
+	if len(b) < 8 {
+		return unreachable{}, false
+	}
+	typ, code, quoted := b[0], b[1], b[8:]
+
+	var dst netip.Addr
+	if v6 {
+		// Extension headers are not followed
+		if typ != 1 || len(quoted) < 40 || quoted[6] != 6 {
+			return unreachable{}, false
+		}
+		dst = netip.AddrFrom16([16]byte(quoted[24:40])).Unmap()
+		quoted = quoted[40:]
+	} else {
+		if typ != 3 || len(quoted) < 20 || quoted[9] != 6 {
+			return unreachable{}, false
+		}
+		ihl := int(quoted[0]&0x0f) * 4
+		if ihl < 20 || len(quoted) < ihl {
+			return unreachable{}, false
+		}
+		dst = netip.AddrFrom4([4]byte(quoted[16:20]))
+		quoted = quoted[ihl:]
+	}
+	if len(quoted) < 8 {
+		return unreachable{}, false
+	}
+
+	state, reason := unreachableState(code, v6)
+	return unreachable{
+		key: synKey{
+			addr:  dst,
+			port:  binary.BigEndian.Uint16(quoted[2:4]),
+			local: binary.BigEndian.Uint16(quoted[0:2]),
+		},
+		seq:    binary.BigEndian.Uint32(quoted[4:8]),
+		state:  state,
+		reason: reason,
+	}, true
+}
+
+// unreachableState converts the code of a destination unreachable message
+// into a port state, the way the kernel reports them to connect scans. A port
+// that is unreachable is closed, and the ports of unreachable hosts and
+// networks, or of prohibited destinations, are in error.
+func unreachableState(code byte, v6 bool) (state, reason string) {
+	if v6 {
+		switch code {
+		case 0: // No route
+			return metrics.StateError, metrics.ReasonNetworkUnreachable
+		case 1, 5, 6: // Administratively prohibited, policy failure, reject route
+			return metrics.StateError, metrics.ReasonPermission
+		case 2, 3: // Beyond scope, address unreachable
+			return metrics.StateError, metrics.ReasonHostUnreachable
+		case 4: // Port unreachable
+			return metrics.StateClosed, metrics.ReasonRefused
+		}
+		return metrics.StateError, metrics.ReasonOther
+	}
+
+	switch code {
+	case 0, 6, 11: // Network unreachable, unknown, unreachable for TOS
+		return metrics.StateError, metrics.ReasonNetworkUnreachable
+	case 1, 7, 12: // Host unreachable, unknown, unreachable for TOS
+		return metrics.StateError, metrics.ReasonHostUnreachable
+	case 3: // Port unreachable
+		return metrics.StateClosed, metrics.ReasonRefused
+	case 9, 10, 13: // Network, host or communication administratively prohibited
+		return metrics.StateError, metrics.ReasonPermission
+	}
+	return metrics.StateError, metrics.ReasonOther
+}
+
+// synState converts the flags of the answer to a SYN into a port state: open
+// for a SYN-ACK and closed for a RST.
+func synState(flags byte) (state, reason string) {
+	switch {
+	case flags&(tcpSYN|tcpACK) == tcpSYN|tcpACK:
+		return metrics.StateOpen, ""
+	case flags&tcpRST != 0:
+		return metrics.StateClosed, metrics.ReasonRefused
+	default:
+		return metrics.StateError, metrics.ReasonOther
+	}
+}
+
+// synScanPort scans a single TCP port of an IP of t with a SYN and sends the
+// result through singleResult, like scanPort. The port is filtered when
+// nothing answers before the timeout. If grab is set, an open port is then
+// connected to, to be probed. Otherwise, it is only tried with a TLS
+// handshake.
+func (s *Scanner) synScanPort(t *target, ip string, port int, grab bool, singleResult chan result) (portInfo, bool) {
+	target := net.JoinHostPort(ip, strconv.Itoa(port))
+	start := time.Now()
+	state, reason, err := s.syn.scan(ip, port, s.Timeout)
+	if err != nil {
+		state, reason = dialState(err)
+		s.Logger.Debug().Err(err).Msgf("error scanning %s", target)
+	}
+	singleResult <- newResult(t.name, ip, port, "tcp", state, reason, start)
+
+	if state != metrics.StateOpen {
+		return portInfo{}, false
+	}
+	if !grab {
+		return s.probeTLS(target, t.host)
+	}
+	conn, err := net.DialTimeout("tcp", target, s.Timeout)
+	if err != nil {
+		s.Logger.Debug().Err(err).Msgf("cannot connect to %s to probe it", target)
+		return portInfo{}, false
+	}
+	defer conn.Close()
+	return s.probe(conn, target, t.host), true
+}
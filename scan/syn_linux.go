@@ -0,0 +1,313 @@
+//go:build linux
+
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/devops-works/scan-exporter/metrics"
+)
+
+// synScanner sends SYNs on raw sockets and hands their answers to the scans
+// waiting for them. The kernel does not know about the half-open connections,
+// so it resets them itself when a SYN-ACK comes back. ICMP destination
+// unreachable errors about the SYNs are read from raw ICMP sockets.
+type synScanner struct {
+	// tcp4 and tcp6 are the IPv4 and IPv6 raw sockets, and icmp4 and icmp6
+	// the ones reading their ICMP errors. They are nil when they cannot be
+	// opened.
+	tcp4, tcp6, icmp4, icmp6 *rawSocket
+
+	mu sync.Mutex
+	// waiting holds the sequence number of the SYNs waiting for an answer,
+	// and the channel their answer is sent to
+	waiting map[synKey]synWaiter
+
+	// sources caches the local address used to reach each destination
+	sources sync.Map
+}
+
+type synWaiter struct {
+	seq    uint32
+	answer chan synAnswer
+}
+
+// synAnswer is the state of a port, from the answer to its SYN.
+type synAnswer struct {
+	state, reason string
+}
+
+// rawSocket is a raw socket handled by the runtime poller, so closing it stops
+// the reads waiting on it.
+type rawSocket struct {
+	file *os.File
+	conn syscall.RawConn
+}
+
+// openRawSocket opens a raw socket of an address family, receiving proto.
+func openRawSocket(family, proto int) (*rawSocket, error) {
+	fd, err := syscall.Socket(family, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, proto)
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), "raw socket")
+	conn, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &rawSocket{file: file, conn: conn}, nil
+}
+
+// sendto sends b to the address to.
+func (r *rawSocket) sendto(b []byte, to syscall.Sockaddr) error {
+	var err error
+	if werr := r.conn.Write(func(fd uintptr) bool {
+		err = syscall.Sendto(int(fd), b, 0, to)
+		return !errors.Is(err, syscall.EAGAIN)
+	}); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// recvfrom waits for a packet and reads it into b. It fails once the socket is
+// closed.
+func (r *rawSocket) recvfrom(b []byte) (n int, from syscall.Sockaddr, err error) {
+	if rerr := r.conn.Read(func(fd uintptr) bool {
+		n, from, err = syscall.Recvfrom(int(fd), b, 0)
+		return !errors.Is(err, syscall.EAGAIN)
+	}); rerr != nil {
+		return 0, nil, rerr
+	}
+	return n, from, err
+}
+
+// newSynScanner opens the raw sockets. It fails without the CAP_NET_RAW
+// capability. Hosts without IPv6 only get IPv4 sockets. Without the ICMP
+// sockets, ports whose SYNs get an ICMP error are reported as filtered.
+func newSynScanner() (*synScanner, error) {
+	tcp4, err := openRawSocket(syscall.AF_INET, syscall.IPPROTO_TCP)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open raw socket: %w", err)
+	}
+
+	s := &synScanner{tcp4: tcp4, waiting: make(map[synKey]synWaiter)}
+	go s.receive(tcp4, false)
+	if s.icmp4, err = openRawSocket(syscall.AF_INET, syscall.IPPROTO_ICMP); err == nil {
+		go s.receiveICMP(s.icmp4, false)
+	}
+	if s.tcp6, err = openRawSocket(syscall.AF_INET6, syscall.IPPROTO_TCP); err == nil {
+		go s.receive(s.tcp6, true)
+		if s.icmp6, err = openRawSocket(syscall.AF_INET6, syscall.IPPROTO_ICMPV6); err == nil {
+			go s.receiveICMP(s.icmp6, true)
+		}
+	}
+	return s, nil
+}
+
+// close closes the raw sockets, which stops their readers. No scan must be
+// running.
+func (s *synScanner) close() {
+	for _, r := range []*rawSocket{s.tcp4, s.tcp6, s.icmp4, s.icmp6} {
+		if r != nil {
+			r.file.Close()
+		}
+	}
+}
+
+// supports reports whether ip can be scanned with SYNs.
+func (s *synScanner) supports(ip string) bool {
+	if s == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	if addr.Unmap().Is4() {
+		return s.tcp4 != nil
+	}
+	return s.tcp6 != nil
+}
+
+// scan sends a SYN to a port of ip and waits for the answer until timeout.
+// The error is set when the SYN cannot be sent.
+func (s *synScanner) scan(ip string, port int, timeout time.Duration) (state, reason string, err error) {
+	dst, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", "", err
+	}
+	zone := dst.Zone()
+	dst = dst.Unmap().WithZone("")
+	src, err := s.source(dst, zone)
+	if err != nil {
+		return "", "", err
+	}
+
+	w := synWaiter{seq: rand.Uint32(), answer: make(chan synAnswer, 1)}
+	key := synKey{addr: dst, port: uint16(port)}
+	s.mu.Lock()
+	for {
+		// Ephemeral port range of Linux
+		key.local = uint16(32768 + rand.IntN(28232))
+		if _, used := s.waiting[key]; !used {
+			break
+		}
+	}
+	s.waiting[key] = w
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.waiting, key)
+		s.mu.Unlock()
+	}()
+
+	pkt := synPacket(netip.AddrPortFrom(src, key.local), netip.AddrPortFrom(dst, key.port), w.seq)
+	if dst.Is4() {
+		err = s.tcp4.sendto(pkt, &syscall.SockaddrInet4{Addr: dst.As4()})
+	} else {
+		err = s.tcp6.sendto(pkt, &syscall.SockaddrInet6{Addr: dst.As16(), ZoneId: zoneIndex(zone)})
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case a := <-w.answer:
+		return a.state, a.reason, nil
+	case <-timer.C:
+		return metrics.StateFiltered, metrics.ReasonTimeout, nil
+	}
+}
+
+// answer hands the answer to a SYN to its scan, if it is still waiting for
+// it.
+func (s *synScanner) answer(key synKey, seq uint32, a synAnswer) {
+	s.mu.Lock()
+	w, ok := s.waiting[key]
+	s.mu.Unlock()
+	if ok && seq == w.seq {
+		select {
+		case w.answer <- a:
+		default:
+		}
+	}
+}
+
+// source returns the local address the kernel uses to reach dst. Connecting
+// a UDP socket selects the route without sending anything.
+func (s *synScanner) source(dst netip.Addr, zone string) (netip.Addr, error) {
+	if src, ok := s.sources.Load(dst); ok {
+		return src.(netip.Addr), nil
+	}
+
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(dst.WithZone(zone), 9)))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer conn.Close()
+	src := conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap().WithZone("")
+	s.sources.Store(dst, src)
+	return src, nil
+}
+
+// receive reads the TCP segments received on a raw socket, and hands the ones
+// answering a SYN to its scan. IPv4 raw sockets receive the IP header too.
+func (s *synScanner) receive(r *rawSocket, v6 bool) {
+	buf := make([]byte, 256)
+	for {
+		n, from, err := r.recvfrom(buf)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return
+		}
+
+		b := buf[:n]
+		var addr netip.Addr
+		switch sa := from.(type) {
+		case *syscall.SockaddrInet4:
+			addr = netip.AddrFrom4(sa.Addr)
+		case *syscall.SockaddrInet6:
+			addr = netip.AddrFrom16(sa.Addr).Unmap()
+		default:
+			continue
+		}
+		if !v6 {
+			if b, err = skipIPv4Header(b); err != nil {
+				continue
+			}
+		}
+
+		seg, ok := parseSegment(b)
+		if !ok {
+			continue
+		}
+		state, reason := synState(seg.flags)
+		s.answer(synKey{addr: addr, port: seg.srcPort, local: seg.dstPort}, seg.ack-1, synAnswer{state: state, reason: reason})
+	}
+}
+
+// receiveICMP reads the ICMP messages received on a raw socket, and hands the
+// destination unreachable errors about a SYN to its scan.
+func (s *synScanner) receiveICMP(r *rawSocket, v6 bool) {
+	buf := make([]byte, 512)
+	for {
+		n, _, err := r.recvfrom(buf)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return
+		}
+
+		b := buf[:n]
+		if !v6 {
+			if b, err = skipIPv4Header(b); err != nil {
+				continue
+			}
+		}
+
+		u, ok := parseUnreachable(b, v6)
+		if !ok {
+			continue
+		}
+		s.answer(u.key, u.seq, synAnswer{state: u.state, reason: u.reason})
+	}
+}
+
+// skipIPv4Header returns what follows the IPv4 header of a packet.
+func skipIPv4Header(b []byte) ([]byte, error) {
+	if len(b) < 20 || len(b) < int(b[0]&0x0f)*4 {
+		return nil, errors.New("truncated IPv4 header")
+	}
+	return b[int(b[0]&0x0f)*4:], nil
+}
+
+// zoneIndex returns the index of the interface of an IPv6 zone, 0 if there is
+// none.
+func zoneIndex(zone string) uint32 {
+	if zone == "" {
+		return 0
+	}
+	if i, err := strconv.Atoi(zone); err == nil {
+		return uint32(i)
+	}
+	if ifi, err := net.InterfaceByName(zone); err == nil {
+		return uint32(ifi.Index)
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+
+package scan
+
+import (
+	"errors"
+	"time"
+)
+
+// synScanner is not available outside of Linux, where scans fall back to
+// connect scans.
+type synScanner struct{}
+
+// newSynScanner always fails outside of Linux.
+func newSynScanner() (*synScanner, error) {
+	return nil, errors.New("SYN scans are only supported on Linux")
+}
+
+// close does nothing, as there are no sockets.
+func (s *synScanner) close() {}
+
+// supports reports whether ip can be scanned with SYNs, which is never.
+func (s *synScanner) supports(ip string) bool {
+	return false
+}
+
+// scan is never called, as no address is supported.
+func (s *synScanner) scan(ip string, port int, timeout time.Duration) (state, reason string, err error) {
+	return "", "", errors.New("SYN scans are only supported on Linux")
+}
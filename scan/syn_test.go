@@ -0,0 +1,260 @@
+package scan
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/devops-works/scan-exporter/metrics"
+	"github.com/rs/zerolog"
+)
+
+func Test_readMethod(t *testing.T) {
+	tests := []struct {
+		method  string
+		want    string
+		wantErr bool
+	}{
+		{method: "", want: MethodConnect},
+		{method: "connect", want: MethodConnect},
+		{method: "syn", want: MethodSYN},
+		{method: "SYN", wantErr: true},
+		{method: "fin", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			got, err := readMethod(tt.method)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("readMethod() = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_synPacket(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst string
+	}{
+		{name: "ipv4", src: "192.0.2.1:40000", dst: "198.51.100.42:443"},
+		{name: "ipv6", src: "[2001:db8::1]:40000", dst: "[2001:db8::42]:22"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := netip.MustParseAddrPort(tt.src), netip.MustParseAddrPort(tt.dst)
+			b := synPacket(src, dst, 0xdeadbeef)
+			if len(b) != synHeaderLen {
+				t.Fatalf("synPacket() is %d bytes long, want %d", len(b), synHeaderLen)
+			}
+			seg, ok := parseSegment(b)
+			if !ok || seg.srcPort != src.Port() || seg.dstPort != dst.Port() || seg.flags != tcpSYN {
+				t.Errorf("synPacket() = %+v, want a SYN from %d to %d", seg, src.Port(), dst.Port())
+			}
+			if b[12]>>4 != synHeaderLen/4 {
+				t.Errorf("synPacket() data offset = %d, want %d", b[12]>>4, synHeaderLen/4)
+			}
+			// A segment with a valid checksum sums to 0
+			if sum := tcpChecksum(src.Addr(), dst.Addr(), b); sum != 0 {
+				t.Errorf("checksum of synPacket() = %#04x, want 0", sum)
+			}
+		})
+	}
+}
+
+func Test_parseSegment(t *testing.T) {
+	b := []byte{
+		0x01, 0xbb, 0x9c, 0x40, // ports 443 and 40000
+		0, 0, 0, 1, // sequence number
+		0xde, 0xad, 0xbe, 0xf0, // acknowledgment number
+		0x50, tcpSYN | tcpACK, 0xff, 0xff, // data offset, flags and window
+		0, 0, 0, 0, // checksum and urgent pointer
+	}
+	want := segment{srcPort: 443, dstPort: 40000, ack: 0xdeadbef0, flags: tcpSYN | tcpACK}
+	if got, ok := parseSegment(b); !ok || got != want {
+		t.Errorf("parseSegment() = %+v, %v, want %+v", got, ok, want)
+	}
+	if _, ok := parseSegment(b[:19]); ok {
+		t.Errorf("parseSegment() of a truncated header is ok, want it rejected")
+	}
+}
+
+func Test_synState(t *testing.T) {
+	tests := []struct {
+		name       string
+		flags      byte
+		want       string
+		wantReason string
+	}{
+		{name: "syn-ack", flags: tcpSYN | tcpACK, want: metrics.StateOpen},
+		{name: "rst", flags: tcpRST | tcpACK, want: metrics.StateClosed, wantReason: metrics.ReasonRefused},
+		{name: "ack", flags: tcpACK, want: metrics.StateError, wantReason: metrics.ReasonOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, reason := synState(tt.flags); got != tt.want || reason != tt.wantReason {
+				t.Errorf("synState() = %v, %v, want %v, %v", got, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func Test_parseUnreachable(t *testing.T) {
+	// quoted is the start of a SYN from port 40000 to port 443, with sequence
+	// number 0xdeadbeef
+	quoted := []byte{0x9c, 0x40, 0x01, 0xbb, 0xde, 0xad, 0xbe, 0xef}
+	ipv4 := func(proto byte) []byte {
+		h := make([]byte, 20)
+		h[0], h[9] = 0x45, proto
+		copy(h[16:], []byte{198, 51, 100, 42})
+		return h
+	}
+	ipv6 := func(next byte) []byte {
+		h := make([]byte, 40)
+		h[0], h[6] = 0x60, next
+		a := netip.MustParseAddr("2001:db8::42").As16()
+		copy(h[24:], a[:])
+		return h
+	}
+	msg := func(typ, code byte, header []byte, seg []byte) []byte {
+		return append(append([]byte{typ, code, 0, 0, 0, 0, 0, 0}, header...), seg...)
+	}
+
+	tests := []struct {
+		name       string
+		b          []byte
+		v6         bool
+		wantAddr   string
+		want       string
+		wantReason string
+		wantOK     bool
+	}{
+		{name: "admin prohibited", b: msg(3, 13, ipv4(6), quoted), wantAddr: "198.51.100.42", want: metrics.StateError, wantReason: metrics.ReasonPermission, wantOK: true},
+		{name: "host unreachable", b: msg(3, 1, ipv4(6), quoted), wantAddr: "198.51.100.42", want: metrics.StateError, wantReason: metrics.ReasonHostUnreachable, wantOK: true},
+		{name: "port unreachable", b: msg(3, 3, ipv4(6), quoted), wantAddr: "198.51.100.42", want: metrics.StateClosed, wantReason: metrics.ReasonRefused, wantOK: true},
+		{name: "ipv6 no route", b: msg(1, 0, ipv6(6), quoted), v6: true, wantAddr: "2001:db8::42", want: metrics.StateError, wantReason: metrics.ReasonNetworkUnreachable, wantOK: true},
+		{name: "ipv6 admin prohibited", b: msg(1, 1, ipv6(6), quoted), v6: true, wantAddr: "2001:db8::42", want: metrics.StateError, wantReason: metrics.ReasonPermission, wantOK: true},
+		{name: "udp", b: msg(3, 3, ipv4(17), quoted)},
+		{name: "ipv6 udp", b: msg(1, 4, ipv6(17), quoted), v6: true},
+		{name: "echo reply", b: msg(0, 0, ipv4(6), quoted)},
+		{name: "truncated segment", b: msg(3, 1, ipv4(6), quoted[:4])},
+		{name: "truncated header", b: msg(3, 1, ipv4(6)[:10], nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseUnreachable(tt.b, tt.v6)
+			if ok != tt.wantOK {
+				t.Fatalf("parseUnreachable() = %+v, %v, want ok %v", got, ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			want := unreachable{
+				key:    synKey{addr: netip.MustParseAddr(tt.wantAddr), port: 443, local: 40000},
+				seq:    0xdeadbeef,
+				state:  tt.want,
+				reason: tt.wantReason,
+			}
+			if got != want {
+				t.Errorf("parseUnreachable() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestScanner_configure_syn(t *testing.T) {
+	s := Scanner{Logger: zerolog.Nop()}
+	c := &config.Conf{Timeout: 1, Limit: 10, Targets: []config.Target{{Name: "app1", IP: "127.0.0.1"}}}
+	c.Targets[0].TCP.Method = MethodSYN
+
+	if err := s.configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if s.synErr != nil {
+		t.Skipf("cannot run SYN scans: %v", s.synErr)
+	}
+	syn := s.syn
+
+	// The raw sockets are closed once no target needs them
+	c.Targets[0].TCP.Method = MethodConnect
+	if err := s.configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if s.syn != nil {
+		t.Errorf("SYN scanner kept without SYN targets")
+	}
+	if _, _, err := syn.scan("127.0.0.1", 22, time.Second); err == nil {
+		t.Errorf("scan() with closed raw sockets succeeded, want an error")
+	}
+}
+
+func TestScanner_synScanPort(t *testing.T) {
+	syn, err := newSynScanner()
+	if err != nil {
+		t.Skipf("cannot run SYN scans: %v", err)
+	}
+
+	open, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+
+	// Bind then release a port, so it is most likely closed
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		port       int
+		want       string
+		wantReason string
+	}{
+		{name: "open", port: open.Addr().(*net.TCPAddr).Port, want: metrics.StateOpen},
+		{name: "closed", port: closedPort, want: metrics.StateClosed, wantReason: metrics.ReasonRefused},
+	}
+	s := Scanner{Timeout: time.Second, syn: syn}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := make(chan result, 1)
+			s.synScanPort(&target{name: "app1"}, "127.0.0.1", tt.port, false, res)
+			got := <-res
+			if got.name != "app1" || got.port != tt.port || got.proto != "tcp" || got.state != tt.want || got.reason != tt.wantReason {
+				t.Errorf("synScanPort() = %+v, want %s with reason %q", got, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestScanner_tcpMethod(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		want   string
+	}{
+		{name: "connect", method: MethodConnect, want: MethodConnect},
+		{name: "syn without raw socket", method: MethodSYN, want: MethodConnect},
+	}
+	s := Scanner{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.tcpMethod(&target{tcpMethod: tt.method}, "127.0.0.1"); got != tt.want {
+				t.Errorf("tcpMethod() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	syn, err := newSynScanner()
+	if err != nil {
+		t.Skipf("cannot run SYN scans: %v", err)
+	}
+	s.syn = syn
+	if got := s.tcpMethod(&target{tcpMethod: MethodSYN}, "127.0.0.1"); got != MethodSYN {
+		t.Errorf("tcpMethod() = %q, want %q", got, MethodSYN)
+	}
+}
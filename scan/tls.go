@@ -0,0 +1,89 @@
+package scan
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/devops-works/scan-exporter/metrics"
+)
+
+// defaultTLSMinVersion is the oldest TLS version that is not weak when
+// tls.min_version is not set.
+const defaultTLSMinVersion = tls.VersionTLS12
+
+// tlsVersions maps the supported values of tls.min_version to versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion returns the TLS version of a tls.min_version value.
+func parseTLSVersion(v string) (uint16, error) {
+	if v == "" {
+		return defaultTLSMinVersion, nil
+	}
+	version, ok := tlsVersions[v]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, must be 1.0, 1.1, 1.2 or 1.3", v)
+	}
+	return version, nil
+}
+
+// inspectTLS completes a TLS handshake with addr and returns what has been
+// learned from it, or nil if the handshake failed. Certificates are not
+// verified, and old versions and ciphers are allowed so weak servers can be
+// inspected too. When the negotiated version is not weak, another handshake
+// checks whether an older one is accepted.
+func (s *Scanner) inspectTLS(addr, serverName string) *metrics.TLSInfo {
+	suites := []uint16{}
+	for _, cs := range tls.CipherSuites() {
+		suites = append(suites, cs.ID)
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		suites = append(suites, cs.ID)
+	}
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		CipherSuites:       suites,
+	}
+	dialer := &net.Dialer{Timeout: s.Timeout}
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, cfg)
+	if err != nil {
+		s.Logger.Debug().Err(err).Msgf("no TLS handshake with %s", addr)
+		return nil
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+
+	info := metrics.TLSInfo{
+		Version: tls.VersionName(state.Version),
+		Cipher:  tls.CipherSuiteName(state.CipherSuite),
+		Weak:    state.Version < s.tlsMinVersion,
+	}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		info.Issuer = cert.Issuer.String()
+		info.Subject = cert.Subject.String()
+		info.SANs = append(info.SANs, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			info.SANs = append(info.SANs, ip.String())
+		}
+		info.NotAfter = cert.NotAfter
+	}
+
+	if !info.Weak && s.tlsMinVersion > tls.VersionTLS10 {
+		cfg.MaxVersion = s.tlsMinVersion - 1
+		if conn, err := tls.DialWithDialer(dialer, "tcp", addr, cfg); err == nil {
+			conn.Close()
+			info.Weak = true
+		}
+	}
+
+	return &info
+}
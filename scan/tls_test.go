@@ -0,0 +1,84 @@
+package scan
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestScanner_inspectTLS(t *testing.T) {
+	// Only used for its certificate, valid for example.com and 127.0.0.1
+	srv := httptest.NewTLSServer(nil)
+	defer srv.Close()
+	certs := srv.TLS.Certificates
+
+	handshake := func(conn net.Conn) {
+		conn.(*tls.Conn).Handshake()
+	}
+	modern := serve(t, &tls.Config{Certificates: certs, MinVersion: tls.VersionTLS12}, handshake)
+	legacy := serve(t, &tls.Config{Certificates: certs, MinVersion: tls.VersionTLS10}, handshake)
+	plain := serve(t, nil, func(conn net.Conn) {})
+
+	tests := []struct {
+		name       string
+		port       int
+		minVersion uint16
+		wantTLS    bool
+		wantWeak   bool
+	}{
+		{name: "modern", port: modern, minVersion: tls.VersionTLS12, wantTLS: true},
+		{name: "legacy", port: legacy, minVersion: tls.VersionTLS12, wantTLS: true, wantWeak: true},
+		{name: "legacy allowed", port: legacy, minVersion: tls.VersionTLS10, wantTLS: true},
+		{name: "required 1.3", port: modern, minVersion: tls.VersionTLS13, wantTLS: true, wantWeak: true},
+		{name: "not TLS", port: plain, minVersion: tls.VersionTLS12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Scanner{Timeout: time.Second, tlsMinVersion: tt.minVersion}
+			info := s.inspectTLS(net.JoinHostPort("127.0.0.1", strconv.Itoa(tt.port)), "example.com")
+			if (info != nil) != tt.wantTLS {
+				t.Fatalf("inspectTLS() = %+v, want TLS %v", info, tt.wantTLS)
+			}
+			if info == nil {
+				return
+			}
+			if info.Weak != tt.wantWeak {
+				t.Errorf("inspectTLS() weak = %v, want %v", info.Weak, tt.wantWeak)
+			}
+			if info.Version != "TLS 1.3" || info.Cipher == "" {
+				t.Errorf("inspectTLS() negotiated %s with %s, want TLS 1.3", info.Version, info.Cipher)
+			}
+			if !slices.Contains(info.SANs, "example.com") || !slices.Contains(info.SANs, "127.0.0.1") {
+				t.Errorf("inspectTLS() SANs = %v, want example.com and 127.0.0.1", info.SANs)
+			}
+			if !info.NotAfter.Equal(srv.Certificate().NotAfter) {
+				t.Errorf("inspectTLS() expiry = %v, want %v", info.NotAfter, srv.Certificate().NotAfter)
+			}
+		})
+	}
+}
+
+func Test_parseTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{version: "", want: tls.VersionTLS12},
+		{version: "1.0", want: tls.VersionTLS10},
+		{version: "1.3", want: tls.VersionTLS13},
+		{version: "TLS1.2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := parseTLSVersion(tt.version)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseTLSVersion() = %v, %v, want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package scan
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devops-works/scan-exporter/metrics"
+)
+
+// udpProbes holds protocol-specific payloads for well-known UDP ports. Most UDP
+// services ignore datagrams they can not parse, so an empty payload would
+// rarely get an answer. Ports without a probe receive an empty datagram.
+var udpProbes = map[int][]byte{
+	// DNS query for the root NS records
+	53: {0x13, 0x37, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01},
+	// NTP v3 client request
+	123: append([]byte{0x1b}, make([]byte, 47)...),
+	// RPC portmapper NULL call
+	111: {
+		0x72, 0xfe, 0x1d, 0x13, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01, 0x86, 0xa0,
+		0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	},
+	// NetBIOS node status request
+	137: {
+		0x80, 0xf0, 0x00, 0x10, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x43, 0x4b, 0x41,
+		0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41,
+		0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x00, 0x00, 0x21,
+		0x00, 0x01,
+	},
+	// SNMP v1 get-request of sysDescr with the "public" community
+	161: {
+		0x30, 0x26, 0x02, 0x01, 0x00, 0x04, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0xa0, 0x19, 0x02,
+		0x01, 0x00, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00, 0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08, 0x2b, 0x06,
+		0x01, 0x02, 0x01, 0x01, 0x01, 0x00, 0x05, 0x00,
+	},
+	// SSDP discovery
+	1900: []byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n"),
+	// mDNS, same as DNS
+	5353: {0x13, 0x37, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01},
+}
+
+// scanUDPPort sends a probe to a single UDP port of an IP of t and sends the
+// result through singleResult. When an answer is received, the port is open.
+// When an ICMP port unreachable is received, the port is closed. When nothing
+// is received before the timeout, the port is either open or filtered. Other
+// errors, like an ICMP host unreachable, are reported as error.
+func (s *Scanner) scanUDPPort(t *target, ip string, port int, singleResult chan result) {
+	target := net.JoinHostPort(ip, strconv.Itoa(port))
+	start := time.Now()
+
+	// Dialing UDP does not send anything, but binds the socket to the target
+	// so ICMP errors are reported on it
+	conn, err := net.DialTimeout("udp", target, s.Timeout)
+	if err != nil {
+		// If the error contains the message "too many open files", wait a little
+		// and retry
+		if strings.Contains(err.Error(), "too many open files") {
+			time.Sleep(s.Timeout)
+			s.scanUDPPort(t, ip, port, singleResult)
+			return
+		}
+		s.Logger.Debug().Err(err).Msgf("error scanning %s", target)
+		_, reason := dialState(err)
+		singleResult <- newResult(t.name, ip, port, "udp", metrics.StateError, reason, start)
+		return
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(s.Timeout))
+
+	buf := make([]byte, 512)
+	if _, err = conn.Write(udpProbes[port]); err == nil {
+		_, err = conn.Read(buf)
+	}
+
+	state, reason := metrics.StateOpen, ""
+	if err != nil {
+		// A timeout means no answer at all, so the port can be open or
+		// filtered
+		state, reason = dialState(err)
+		if state == metrics.StateFiltered {
+			state = metrics.StateOpenFiltered
+		}
+		if state == metrics.StateError {
+			s.Logger.Debug().Err(err).Msgf("error scanning %s", target)
+		}
+	}
+
+	singleResult <- newResult(t.name, ip, port, "udp", state, reason, start)
+}
@@ -0,0 +1,63 @@
+package scan
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/devops-works/scan-exporter/metrics"
+)
+
+func TestScanner_scanUDPPort(t *testing.T) {
+	// Answering server, the open port
+	open, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			_, addr, err := open.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			open.WriteTo([]byte("ok"), addr)
+		}
+	}()
+
+	// Bind then release a port, so it is most likely closed
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.LocalAddr().(*net.UDPAddr).Port
+	closed.Close()
+
+	// Silent server, the open|filtered port
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	tests := []struct {
+		name string
+		port int
+		want string
+	}{
+		{name: "open", port: open.LocalAddr().(*net.UDPAddr).Port, want: metrics.StateOpen},
+		{name: "closed", port: closedPort, want: metrics.StateClosed},
+		{name: "open|filtered", port: silent.LocalAddr().(*net.UDPAddr).Port, want: metrics.StateOpenFiltered},
+	}
+	s := Scanner{Timeout: 200 * time.Millisecond}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := make(chan result, 1)
+			s.scanUDPPort(&target{name: "app1"}, "127.0.0.1", tt.port, res)
+			if got := <-res; got.name != "app1" || got.ip != "127.0.0.1" || got.port != tt.port || got.proto != "udp" || got.state != tt.want {
+				t.Errorf("scanUDPPort() = %+v, want %s", got, tt.want)
+			}
+		})
+	}
+}
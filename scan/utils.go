@@ -2,6 +2,7 @@ package scan
 
 import (
 	"fmt"
+	"net/netip"
 	"slices"
 	"strconv"
 	"strings"
@@ -101,3 +102,143 @@ func readPortsRange(ranges string) ([]int, error) {
 
 	return uniquePorts, nil
 }
+
+// readExpected reads expected TCP ports. Each port or range can be followed by
+// the service expected on it, like `443:tls,22:ssh`. It returns the ports, and
+// the expected services by port.
+func readExpected(expected string) ([]int, map[string]string, error) {
+	specs := []string{}
+	services := make(map[string]string)
+	for spec := range strings.SplitSeq(strings.ReplaceAll(expected, " ", ""), ",") {
+		spec, service, found := strings.Cut(spec, ":")
+		specs = append(specs, spec)
+		if !found {
+			continue
+		}
+		if !slices.Contains(Services, service) {
+			return nil, nil, fmt.Errorf("unknown service %q for port %q, must be one of %s", service, spec, strings.Join(Services, ", "))
+		}
+		ports, err := readPortsRange(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, port := range ports {
+			services[strconv.Itoa(port)] = service
+		}
+	}
+
+	ports, err := readPortsRange(strings.Join(specs, ","))
+	if err != nil {
+		return nil, nil, err
+	}
+	return ports, services, nil
+}
+
+// ipRange is a contiguous set of addresses, from first to last included.
+type ipRange struct {
+	first, last netip.Addr
+	// cidr is set when the range comes from a prefix notation
+	cidr netip.Prefix
+}
+
+// parseIPRange reads a single IP (10.0.0.1), a CIDR (10.0.0.0/24) or an
+// hyphenated range (10.0.0.10-10.0.0.50). A single IPv6 address can be
+// enclosed in brackets, as in URLs ([2001:db8::1]).
+func parseIPRange(spec string) (ipRange, error) {
+	spec = strings.TrimSpace(spec)
+
+	switch {
+	case strings.Contains(spec, "/"):
+		prefix, err := netip.ParsePrefix(spec)
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid CIDR %q: %w", spec, err)
+		}
+		prefix = prefix.Masked()
+		return ipRange{first: prefix.Addr(), last: lastAddr(prefix), cidr: prefix}, nil
+	case strings.Contains(spec, "-"):
+		bounds := strings.Split(spec, "-")
+		if len(bounds) != 2 {
+			return ipRange{}, fmt.Errorf("invalid IP range format: %q", spec)
+		}
+		first, err := netip.ParseAddr(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid start IP in range %q: %w", spec, err)
+		}
+		last, err := netip.ParseAddr(strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid end IP in range %q: %w", spec, err)
+		}
+		if first.Is4() != last.Is4() {
+			return ipRange{}, fmt.Errorf("IP range %q mixes IPv4 and IPv6", spec)
+		}
+		if last.Less(first) {
+			return ipRange{}, fmt.Errorf("start IP %s is higher than end IP %s in range %q", first, last, spec)
+		}
+		return ipRange{first: first, last: last}, nil
+	default:
+		addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(spec, "["), "]"))
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid IP %q: %w", spec, err)
+		}
+		return ipRange{first: addr, last: addr}, nil
+	}
+}
+
+// contains reports whether addr is part of the range.
+func (r ipRange) contains(addr netip.Addr) bool {
+	return r.first.Compare(addr) <= 0 && addr.Compare(r.last) <= 0
+}
+
+// lastAddr returns the highest address of a masked prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// expandHosts transforms an IP, CIDR or IP range into the list of addresses it
+// contains, minus the excluded ones. For IPv4 networks larger than /31, the
+// network and broadcast addresses are skipped. An error is returned if more
+// than max addresses would be produced. Excluded ranges are skipped at once, so
+// large exclusions do not cost one step per address.
+func expandHosts(spec string, exclude []string, max int) ([]string, error) {
+	r, err := parseIPRange(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := []ipRange{}
+	for _, e := range exclude {
+		er, err := parseIPRange(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclusion: %w", err)
+		}
+		excluded = append(excluded, er)
+	}
+
+	skipEdges := r.cidr.IsValid() && r.cidr.Addr().Is4() && r.cidr.Bits() < 31
+
+	hosts := []string{}
+	for addr := r.first; addr.IsValid() && addr.Compare(r.last) <= 0; {
+		if skipEdges && (addr == r.first || addr == r.last) {
+			addr = addr.Next()
+			continue
+		}
+		if i := slices.IndexFunc(excluded, func(e ipRange) bool { return e.contains(addr) }); i >= 0 {
+			// Jump past the exclusion, which is the end if it is the last
+			// address
+			addr = excluded[i].last.WithZone(addr.Zone()).Next()
+			continue
+		}
+		if len(hosts) == max {
+			return nil, fmt.Errorf("%s expands to more than %d hosts", spec, max)
+		}
+		hosts = append(hosts, addr.String())
+		addr = addr.Next()
+	}
+
+	return hosts, nil
+}
@@ -92,3 +92,81 @@ func Test_readPortsRange(t *testing.T) {
 		})
 	}
 }
+
+func Test_readExpected(t *testing.T) {
+	tests := []struct {
+		name         string
+		expected     string
+		want         []int
+		wantServices map[string]string
+		wantErr      bool
+	}{
+		{name: "ports only", expected: "22,443", want: []int{22, 443}, wantServices: map[string]string{}},
+		{name: "services", expected: "443:tls, 22:ssh,80", want: []int{22, 80, 443}, wantServices: map[string]string{"22": "ssh", "443": "tls"}},
+		{name: "range with service", expected: "8080-8081:http", want: []int{8080, 8081}, wantServices: map[string]string{"8080": "http", "8081": "http"}},
+		{name: "unknown service", expected: "443:gopher", wantErr: true},
+		{name: "empty service", expected: "443:", wantErr: true},
+		{name: "invalid port", expected: "a:ssh", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, services, err := readExpected(tt.expected)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readExpected() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(services, tt.wantServices) {
+				t.Errorf("readExpected() = %v, %v, want %v, %v", got, services, tt.want, tt.wantServices)
+			}
+		})
+	}
+}
+
+func Test_expandHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		exclude []string
+		max     int
+		want    []string
+		wantErr bool
+	}{
+		{name: "single IP", spec: "10.0.0.1", max: 10, want: []string{"10.0.0.1"}},
+		{name: "cidr", spec: "10.0.0.0/30", max: 10, want: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "cidr not masked", spec: "10.0.0.5/30", max: 10, want: []string{"10.0.0.5", "10.0.0.6"}},
+		{name: "cidr /31", spec: "10.0.0.0/31", max: 10, want: []string{"10.0.0.0", "10.0.0.1"}},
+		{name: "cidr /32", spec: "10.0.0.7/32", max: 10, want: []string{"10.0.0.7"}},
+		{name: "range", spec: "10.0.0.254-10.0.1.1", max: 10, want: []string{"10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"}},
+		{name: "ipv6 range", spec: "2001:db8::1-2001:db8::3", max: 10, want: []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+		{name: "ipv6 canonical form", spec: "2001:DB8:0::0001", max: 10, want: []string{"2001:db8::1"}},
+		{name: "ipv6 brackets", spec: "[2001:db8::1]", max: 10, want: []string{"2001:db8::1"}},
+		{name: "ipv6 zone", spec: "fe80::1%eth0", max: 10, want: []string{"fe80::1%eth0"}},
+		{name: "ipv6 cidr", spec: "2001:db8::/126", exclude: []string{"2001:db8::2"}, max: 10, want: []string{"2001:db8::", "2001:db8::1", "2001:db8::3"}},
+		{name: "exclusions", spec: "10.0.0.0/29", exclude: []string{"10.0.0.2", "10.0.0.4-10.0.0.5"}, max: 10, want: []string{"10.0.0.1", "10.0.0.3", "10.0.0.6"}},
+		{name: "exclusion cidr", spec: "10.0.0.1-10.0.0.6", exclude: []string{"10.0.0.4/30"}, max: 10, want: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{name: "everything excluded", spec: "10.0.0.1", exclude: []string{"10.0.0.0/24"}, max: 10, want: []string{}},
+		{name: "large exclusion", spec: "10.0.0.0/8", exclude: []string{"10.0.0.0/9", "10.128.0.0-10.255.255.252"}, max: 10, want: []string{"10.255.255.253", "10.255.255.254"}},
+		{name: "large ipv6 exclusion", spec: "2001:db8::/64", exclude: []string{"2001:db8::/65", "2001:db8::8000:0:0:0-2001:db8::ffff:ffff:ffff:fffd"}, max: 10, want: []string{"2001:db8::ffff:ffff:ffff:fffe", "2001:db8::ffff:ffff:ffff:ffff"}},
+		{name: "exclusion up to the last address", spec: "::/0", exclude: []string{"::/1", "8000::/1"}, max: 10, want: []string{}},
+		{name: "exclusions do not count in max", spec: "10.0.0.0/29", exclude: []string{"10.0.0.1-10.0.0.4"}, max: 2, want: []string{"10.0.0.5", "10.0.0.6"}},
+
+		{name: "too many hosts", spec: "10.0.0.0/24", max: 100, wantErr: true},
+		{name: "too many hosts after a large exclusion", spec: "10.0.0.0/8", exclude: []string{"10.0.0.0/9"}, max: 100, wantErr: true},
+		{name: "invalid IP", spec: "10.0.0.256", max: 10, wantErr: true},
+		{name: "invalid cidr", spec: "10.0.0.0/33", max: 10, wantErr: true},
+		{name: "reversed range", spec: "10.0.0.50-10.0.0.10", max: 100, wantErr: true},
+		{name: "mixed range", spec: "10.0.0.1-2001:db8::1", max: 100, wantErr: true},
+		{name: "invalid exclusion", spec: "10.0.0.1", exclude: []string{"foo"}, max: 10, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandHosts(tt.spec, tt.exclude, tt.max)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expandHosts() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandHosts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package scan
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/rs/zerolog"
+)
+
+// ValidationError is an invalid value of the configuration. Field is its path
+// in the file, like `targets[2].tcp.period`, and Target the name of the target
+// it belongs to, if any. Source is set when the target has been imported from
+// a target file.
+type ValidationError struct {
+	Field  string
+	Target string
+	Source string
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Field, e.Err)
+	if e.Target != "" {
+		msg = fmt.Sprintf("target %q: %s", e.Target, msg)
+	}
+	if e.Source != "" {
+		msg = e.Source + ": " + msg
+	}
+	return msg
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks every value of the configuration the scanner uses, and
+// returns all the errors found.
+func Validate(c *config.Conf) []*ValidationError {
+	errs := []*ValidationError{}
+	add := func(field, target string, format string, a ...any) {
+		errs = append(errs, &ValidationError{Field: field, Target: target, Err: fmt.Errorf(format, a...)})
+	}
+
+	// period checks a period. ICMP periods can be "0" to disable pings.
+	period := func(field, target, value string, icmp bool) {
+		if value == "" || (icmp && value == "0") {
+			return
+		}
+		d, err := getDuration(value)
+		switch {
+		case err != nil:
+			add(field, target, "invalid period %q: %w", value, err)
+		case d <= 0:
+			add(field, target, "period %q must be positive", value)
+		}
+	}
+	// ports checks a port specification.
+	ports := func(field, target, value string) {
+		if _, err := readPortsRange(value); err != nil {
+			add(field, target, "%w", err)
+		}
+	}
+
+	if c.Timeout <= 0 {
+		add("timeout", "", "must be a positive number of seconds")
+	}
+	if c.Limit <= 0 {
+		add("limit", "", "must be positive")
+	}
+	if c.LogLevel != "" {
+		if _, err := zerolog.ParseLevel(c.LogLevel); err != nil {
+			add("log_level", "", "unknown level %q", c.LogLevel)
+		}
+	}
+	if c.QueriesPerSecond < 0 {
+		add("queries_per_sec", "", "must not be negative")
+	}
+	if c.MaxHosts < 0 {
+		add("max_hosts", "", "must not be negative")
+	}
+	if c.PortStateLimit < 0 {
+		add("port_state_limit", "", "must not be negative")
+	}
+	if c.Storage.HistoryLength < 0 {
+		add("storage.history_length", "", "must not be negative")
+	}
+	if _, err := parseTLSVersion(c.TLS.MinVersion); err != nil {
+		add("tls.min_version", "", "%w", err)
+	}
+	period("tcp_period", "", c.TcpPeriod, false)
+	period("udp_period", "", c.UdpPeriod, false)
+	period("icmp_period", "", c.IcmpPeriod, true)
+
+	maxHosts := c.MaxHosts
+	if maxHosts <= 0 {
+		maxHosts = defaultMaxHosts
+	}
+
+	// names holds the index of the first target using each name
+	names := make(map[string]int)
+	for i, t := range c.Targets {
+		path := fmt.Sprintf("targets[%d]", i)
+		name := t.Name
+		firstErr := len(errs)
+
+		switch first, ok := names[name]; {
+		case name == "":
+			add(path+".name", "", "missing target name")
+		case ok:
+			where := fmt.Sprintf("targets[%d]", first)
+			if source := c.Targets[first].Source; source != "" {
+				where = source
+			}
+			add(path+".name", name, "duplicate name, already used by %s", where)
+		default:
+			names[name] = i
+		}
+
+		validExclude := true
+		for j, e := range t.Exclude {
+			if _, err := parseIPRange(e); err != nil {
+				add(fmt.Sprintf("%s.exclude[%d]", path, j), name, "%w", err)
+				validExclude = false
+			}
+		}
+		switch {
+		case t.IP != "" && t.Host != "":
+			add(path, name, "ip and host are mutually exclusive")
+		case t.IP == "" && t.Host == "":
+			add(path, name, "one of ip or host is required")
+		case t.IP != "" && validExclude:
+			if _, err := expandHosts(t.IP, t.Exclude, maxHosts); err != nil {
+				add(path+".ip", name, "%w", err)
+			}
+		}
+
+		if t.QueriesPerSecond < 0 {
+			add(path+".queries_per_sec", name, "must not be negative")
+		}
+
+		period(path+".tcp.period", name, t.TCP.Period, false)
+		period(path+".udp.period", name, t.UDP.Period, false)
+		period(path+".icmp.period", name, t.ICMP.Period, true)
+		ports(path+".tcp.range", name, t.TCP.Range)
+		if _, _, err := readExpected(t.TCP.Expected); err != nil {
+			add(path+".tcp.expected", name, "%w", err)
+		}
+		if _, err := readMethod(t.TCP.Method); err != nil {
+			add(path+".tcp.method", name, "%w", err)
+		}
+		ports(path+".http.ports", name, t.HTTP.Ports)
+		if t.HTTP.Path != "" && !strings.HasPrefix(t.HTTP.Path, "/") {
+			add(path+".http.path", name, "path %q must start with /", t.HTTP.Path)
+		}
+		// Expected answers can only come from scanned ports
+		scanned, _ := readPortsRange(t.TCP.Range)
+		for j, e := range t.ExpectedHTTP {
+			field := fmt.Sprintf("%s.expected_http[%d]", path, j)
+			switch {
+			case e.Port < 1 || e.Port > 65535:
+				add(field+".port", name, "invalid port %d", e.Port)
+			case !slices.Contains(scanned, e.Port):
+				add(field+".port", name, "port %d is not in tcp.range", e.Port)
+			}
+			if e.Status != 0 && (e.Status < 100 || e.Status > 599) {
+				add(field+".status", name, "invalid status code %d", e.Status)
+			}
+		}
+		ports(path+".udp.range", name, t.UDP.Range)
+		ports(path+".udp.expected", name, t.UDP.Expected)
+
+		// Scanned protocols need a period, either global or from the target
+		if (t.TCP.Range != "" || t.TCP.Expected != "") && t.TCP.Period == "" && c.TcpPeriod == "" {
+			add(path+".tcp", name, "no TCP period, set tcp.period or tcp_period")
+		}
+		if (t.UDP.Range != "" || t.UDP.Expected != "") && t.UDP.Period == "" && c.UdpPeriod == "" {
+			add(path+".udp", name, "no UDP period, set udp.period or udp_period")
+		}
+
+		for _, err := range errs[firstErr:] {
+			err.Source = t.Source
+		}
+	}
+
+	return errs
+}
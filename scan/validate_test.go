@@ -0,0 +1,98 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/devops-works/scan-exporter/config"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() *config.Conf {
+		c := &config.Conf{Timeout: 2, Limit: 1024, TcpPeriod: "6h"}
+		c.Targets = []config.Target{{Name: "app1", IP: "10.0.0.0/24"}, {Name: "app2", Host: "example.com"}}
+		c.Targets[0].TCP.Range = "reserved"
+		c.Targets[0].ICMP.Period = "0"
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *config.Conf)
+		want   []string
+	}{
+		{name: "valid", modify: func(c *config.Conf) {}},
+		{
+			name: "global values",
+			modify: func(c *config.Conf) {
+				c.Timeout = 0
+				c.LogLevel = "loud"
+				c.IcmpPeriod = "0d"
+				c.TLS.MinVersion = "1.4"
+			},
+			want: []string{"timeout", "log_level", "tls.min_version", "icmp_period"},
+		},
+		{
+			name: "target values",
+			modify: func(c *config.Conf) {
+				c.Targets[0].IP = "10.0.0.0/8"
+				c.Targets[0].TCP.Expected = "22,a"
+				c.Targets[1].Name = "app1"
+				c.Targets[1].UDP.Range = "53"
+			},
+			want: []string{"targets[0].ip", "targets[0].tcp.expected", "targets[1].name", "targets[1].udp"},
+		},
+		{
+			name: "expected services",
+			modify: func(c *config.Conf) {
+				c.Targets[0].TCP.Expected = "22:ssh,443:tls"
+				c.Targets[1].TCP.Expected = "80:gopher"
+				c.Targets[1].UDP.Expected = "53:dns"
+			},
+			want: []string{"targets[1].tcp.expected", "targets[1].udp.expected", "targets[1].udp"},
+		},
+		{
+			name: "http",
+			modify: func(c *config.Conf) {
+				c.Targets[0].HTTP = config.HTTP{Ports: "80,8080-", Path: "index.html"}
+				c.Targets[0].ExpectedHTTP = []config.ExpectedHTTP{{Port: 80, Status: 301}, {Port: 8080}, {Port: 443, Status: 1000}}
+			},
+			want: []string{
+				"targets[0].http.ports", "targets[0].http.path",
+				"targets[0].expected_http[1].port", "targets[0].expected_http[2].status",
+			},
+		},
+		{
+			name: "tcp method",
+			modify: func(c *config.Conf) {
+				c.Targets[0].TCP.Method = MethodSYN
+				c.Targets[1].TCP.Method = "fin"
+			},
+			want: []string{"targets[1].tcp.method"},
+		},
+		{
+			name: "address",
+			modify: func(c *config.Conf) {
+				c.Targets[0].Host = "example.com"
+				c.Targets[1].Host = ""
+				c.Targets[0].Exclude = []string{"10.0.0.300"}
+			},
+			want: []string{"targets[0].exclude[0]", "targets[0]", "targets[1]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+
+			errs := Validate(c)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("Validate() = %v, want errors on %v", errs, tt.want)
+			}
+			for i, field := range tt.want {
+				if errs[i].Field != field {
+					t.Errorf("error %d is on %s, want %s: %v", i, errs[i].Field, field, errs[i])
+				}
+			}
+		})
+	}
+}
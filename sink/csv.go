@@ -0,0 +1,45 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"time"
+)
+
+// CSV writes one row per scanned port. Each file starts with a header.
+type CSV struct {
+	file *rotatingFile
+}
+
+// csvHeader returns the first row of CSV files.
+func csvHeader() []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"time", "name", "ip", "proto", "port", "state", "expected", "banner", "service", "http_status", "http_location", "http_server", "http_title", "http_seconds", "latency_seconds", "reason"})
+	w.Flush()
+	return buf.Bytes()
+}
+
+// Write appends the ports of the result to the file.
+func (c *CSV) Write(r Result) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	t := r.Time.Format(time.RFC3339)
+	for _, p := range r.Ports {
+		row := []string{t, r.Name, r.IP, r.Proto, strconv.Itoa(p.Port), p.State, strconv.FormatBool(p.Expected), p.Banner, p.Service, "", "", "", "", "",
+			strconv.FormatFloat(p.Latency, 'f', 6, 64), p.Reason}
+		if h := p.HTTP; h != nil && h.Error == "" {
+			copy(row[9:], []string{strconv.Itoa(h.Status), h.Location, h.Server, h.Title, strconv.FormatFloat(h.ResponseSeconds, 'f', 3, 64)})
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return c.file.write(buf.Bytes())
+}
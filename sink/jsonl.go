@@ -0,0 +1,17 @@
+package sink
+
+import "encoding/json"
+
+// JSONLines writes each result as a JSON object on its own line.
+type JSONLines struct {
+	file *rotatingFile
+}
+
+// Write appends the result to the file.
+func (j *JSONLines) Write(r Result) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return j.file.write(append(b, '\n'))
+}
@@ -0,0 +1,519 @@
+package sink
+
+import (
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devops-works/scan-exporter/metrics"
+)
+
+// maxListedPorts is the number of ports in a state above which they are not
+// listed one by one but summed up, like nmap does. Expected ports are always
+// listed.
+const maxListedPorts = 25
+
+// nmapReasons are the nmap reasons of the ports that could not be scanned,
+// by the reason the scanner gave.
+var nmapReasons = map[string]string{
+	metrics.ReasonHostUnreachable:    "host-unreach",
+	metrics.ReasonNetworkUnreachable: "net-unreach",
+	metrics.ReasonPermission:         "admin-prohibited",
+}
+
+// scanType returns the nmap scan type of a result: udp, syn or connect, which
+// is assumed for TCP results that do not tell their method.
+func scanType(r Result) string {
+	switch {
+	case r.Proto == "udp":
+		return "udp"
+	case r.Method != "":
+		return r.Method
+	default:
+		return "connect"
+	}
+}
+
+// nmapState converts a port state to its nmap equivalent, with the reason of
+// the state, which depends on the scan type. Ports that could not be scanned
+// are reported as filtered, with the nmap equivalent of their reason if there
+// is one.
+func nmapState(scanType string, p Port) (string, string) {
+	state := p.State
+	switch {
+	case state == metrics.StateOpen && scanType == "udp":
+		return "open", "udp-response"
+	case state == metrics.StateOpen:
+		return "open", "syn-ack"
+	case state == metrics.StateClosed && scanType == "udp":
+		return "closed", "port-unreach"
+	case state == metrics.StateClosed && scanType == "syn":
+		return "closed", "reset"
+	case state == metrics.StateClosed:
+		return "closed", "conn-refused"
+	case state == metrics.StateOpenFiltered:
+		return "open|filtered", "no-response"
+	case state == metrics.StateFiltered:
+		return "filtered", "no-response"
+	case nmapReasons[p.Reason] != "":
+		return "filtered", nmapReasons[p.Reason]
+	default:
+		return "filtered", "error"
+	}
+}
+
+// nmapHost gathers the results of all the protocols of an IP of a target.
+type nmapHost struct {
+	name, ip   string
+	start, end time.Time
+	ports      []nmapPort
+	extra      []nmapExtraPorts
+	// scanned holds the scanned ports by protocol and scan type
+	scanned map[scanInfoKey][]int
+}
+
+// scanInfoKey identifies a scaninfo element of nmap's XML output.
+type scanInfoKey struct {
+	proto, scanType string
+}
+
+type nmapPort struct {
+	proto, state, reason, banner, service string
+	port                                  int
+	http                                  *HTTP
+}
+
+type nmapExtraPorts struct {
+	proto, state string
+	count        int
+}
+
+// nmapHosts groups results by target and IP, in order of appearance. In each
+// protocol, ports whose state is too common are summed up.
+func nmapHosts(results []Result) []*nmapHost {
+	hosts := []*nmapHost{}
+	byKey := make(map[string]*nmapHost)
+
+	for _, r := range results {
+		key := r.Name + "/" + r.IP
+		h, ok := byKey[key]
+		if !ok {
+			h = &nmapHost{name: r.Name, ip: r.IP, start: r.Start, end: r.Time, scanned: make(map[scanInfoKey][]int)}
+			byKey[key] = h
+			hosts = append(hosts, h)
+		}
+		if r.Start.Before(h.start) {
+			h.start = r.Start
+		}
+		if r.Time.After(h.end) {
+			h.end = r.Time
+		}
+
+		typ := scanType(r)
+		count := make(map[string]int)
+		for _, p := range r.Ports {
+			state, _ := nmapState(typ, p)
+			count[state]++
+		}
+		extra := make(map[string]int)
+		si := scanInfoKey{proto: r.Proto, scanType: typ}
+		for _, p := range r.Ports {
+			h.scanned[si] = append(h.scanned[si], p.Port)
+			state, reason := nmapState(typ, p)
+			if state != "open" && !p.Expected && count[state] > maxListedPorts {
+				extra[state]++
+				continue
+			}
+			h.ports = append(h.ports, nmapPort{proto: r.Proto, state: state, reason: reason, banner: p.Banner, service: p.Service, port: p.Port, http: p.HTTP})
+		}
+		for _, state := range slices.Sorted(maps.Keys(extra)) {
+			h.extra = append(h.extra, nmapExtraPorts{proto: r.Proto, state: state, count: extra[state]})
+		}
+	}
+
+	return hosts
+}
+
+// bounds returns the start of the first scan and the end of the last one.
+func bounds(results []Result) (time.Time, time.Time) {
+	var start, end time.Time
+	for _, r := range results {
+		if start.IsZero() || r.Start.Before(start) {
+			start = r.Start
+		}
+		if r.Time.After(end) {
+			end = r.Time
+		}
+	}
+	return start, end
+}
+
+// servicesList formats ports the way nmap does in scaninfo, with ranges.
+// ports must be sorted and unique.
+func servicesList(ports []int) string {
+	parts := []string{}
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(ports[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", ports[i], ports[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// nmaprun is the root of nmap's XML output. Only the elements that
+// scan-exporter can fill are defined.
+type nmaprun struct {
+	XMLName          xml.Name      `xml:"nmaprun"`
+	Scanner          string        `xml:"scanner,attr"`
+	Args             string        `xml:"args,attr"`
+	Start            int64         `xml:"start,attr"`
+	StartStr         string        `xml:"startstr,attr"`
+	Version          string        `xml:"version,attr"`
+	XMLOutputVersion string        `xml:"xmloutputversion,attr"`
+	ScanInfo         []xmlScanInfo `xml:"scaninfo"`
+	Hosts            []xmlHost     `xml:"host"`
+	RunStats         xmlRunStats   `xml:"runstats"`
+}
+
+type xmlScanInfo struct {
+	Type        string `xml:"type,attr"`
+	Protocol    string `xml:"protocol,attr"`
+	NumServices int    `xml:"numservices,attr"`
+	Services    string `xml:"services,attr"`
+}
+
+type xmlHost struct {
+	StartTime int64         `xml:"starttime,attr"`
+	EndTime   int64         `xml:"endtime,attr"`
+	Status    xmlStatus     `xml:"status"`
+	Address   xmlAddress    `xml:"address"`
+	Hostnames []xmlHostname `xml:"hostnames>hostname"`
+	Ports     xmlPorts      `xml:"ports"`
+}
+
+type xmlStatus struct {
+	State     string `xml:"state,attr"`
+	Reason    string `xml:"reason,attr"`
+	ReasonTTL int    `xml:"reason_ttl,attr"`
+}
+
+type xmlAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+}
+
+type xmlHostname struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type xmlPorts struct {
+	ExtraPorts []xmlExtraPorts `xml:"extraports"`
+	Ports      []xmlPort       `xml:"port"`
+}
+
+type xmlExtraPorts struct {
+	State string `xml:"state,attr"`
+	Count int    `xml:"count,attr"`
+}
+
+type xmlPort struct {
+	Protocol string       `xml:"protocol,attr"`
+	PortID   int          `xml:"portid,attr"`
+	State    xmlPortState `xml:"state"`
+	Service  *xmlService  `xml:"service"`
+	Scripts  []xmlScript  `xml:"script"`
+}
+
+// xmlService is the service identified on a port. Services are identified by
+// probing, like nmap -sV.
+type xmlService struct {
+	Name   string `xml:"name,attr"`
+	Method string `xml:"method,attr"`
+	Conf   int    `xml:"conf,attr"`
+}
+
+type xmlPortState struct {
+	State     string `xml:"state,attr"`
+	Reason    string `xml:"reason,attr"`
+	ReasonTTL int    `xml:"reason_ttl,attr"`
+}
+
+// xmlScript is the output of an NSE script. Banners and HTTP answers are
+// reported like nmap's banner, http-title and http-server-header scripts do.
+type xmlScript struct {
+	ID     string `xml:"id,attr"`
+	Output string `xml:"output,attr"`
+}
+
+type xmlRunStats struct {
+	Finished xmlFinished `xml:"finished"`
+	Hosts    xmlHosts    `xml:"hosts"`
+}
+
+type xmlFinished struct {
+	Time    int64   `xml:"time,attr"`
+	TimeStr string  `xml:"timestr,attr"`
+	Elapsed float64 `xml:"elapsed,attr"`
+	Summary string  `xml:"summary,attr"`
+	Exit    string  `xml:"exit,attr"`
+}
+
+type xmlHosts struct {
+	Up    int `xml:"up,attr"`
+	Down  int `xml:"down,attr"`
+	Total int `xml:"total,attr"`
+}
+
+// WriteNmapXML writes results in nmap's XML format. The results of the
+// different protocols of an IP are merged in a single host, named after the
+// target.
+func WriteNmapXML(w io.Writer, results []Result) error {
+	start, end := bounds(results)
+	hosts := nmapHosts(results)
+
+	run := nmaprun{
+		Scanner:          "scan-exporter",
+		Args:             "scan-exporter",
+		Start:            start.Unix(),
+		StartStr:         start.Format(time.ANSIC),
+		Version:          "1.0",
+		XMLOutputVersion: "1.05",
+		RunStats: xmlRunStats{
+			Finished: xmlFinished{
+				Time:    end.Unix(),
+				TimeStr: end.Format(time.ANSIC),
+				Elapsed: end.Sub(start).Round(10 * time.Millisecond).Seconds(),
+				Summary: summary(end, len(hosts), end.Sub(start)),
+				Exit:    "success",
+			},
+			Hosts: xmlHosts{Up: len(hosts), Total: len(hosts)},
+		},
+	}
+
+	scanInfos := make(map[scanInfoKey][]int)
+	for _, h := range hosts {
+		for si, ports := range h.scanned {
+			scanInfos[si] = append(scanInfos[si], ports...)
+		}
+
+		addrType := "ipv4"
+		if addr, err := netip.ParseAddr(h.ip); err == nil && addr.Is6() && !addr.Is4In6() {
+			addrType = "ipv6"
+		}
+		xh := xmlHost{
+			StartTime: h.start.Unix(),
+			EndTime:   h.end.Unix(),
+			// Hosts are not pinged before being scanned, like nmap -Pn
+			Status:    xmlStatus{State: "up", Reason: "user-set"},
+			Address:   xmlAddress{Addr: h.ip, AddrType: addrType},
+			Hostnames: []xmlHostname{{Name: h.name, Type: "user"}},
+		}
+		for _, e := range h.extra {
+			xh.Ports.ExtraPorts = append(xh.Ports.ExtraPorts, xmlExtraPorts{State: e.state, Count: e.count})
+		}
+		for _, p := range h.ports {
+			xp := xmlPort{
+				Protocol: p.proto,
+				PortID:   p.port,
+				State:    xmlPortState{State: p.state, Reason: p.reason},
+			}
+			if p.service != "" {
+				xp.Service = &xmlService{Name: p.service, Method: "probed", Conf: 10}
+			}
+			if p.banner != "" {
+				xp.Scripts = append(xp.Scripts, xmlScript{ID: "banner", Output: p.banner})
+			}
+			if h := p.http; h != nil && h.Error == "" {
+				title := h.Title
+				switch {
+				case h.Location != "":
+					title = "Did not follow redirect to " + h.Location
+				case title == "":
+					title = "Site doesn't have a title."
+				}
+				xp.Scripts = append(xp.Scripts, xmlScript{ID: "http-title", Output: title})
+				if h.Server != "" {
+					xp.Scripts = append(xp.Scripts, xmlScript{ID: "http-server-header", Output: h.Server})
+				}
+			}
+			xh.Ports.Ports = append(xh.Ports.Ports, xp)
+		}
+		run.Hosts = append(run.Hosts, xh)
+	}
+	keys := slices.SortedFunc(maps.Keys(scanInfos), func(a, b scanInfoKey) int {
+		return cmp.Or(cmp.Compare(a.proto, b.proto), cmp.Compare(a.scanType, b.scanType))
+	})
+	for _, si := range keys {
+		ports := slices.Compact(slices.Sorted(slices.Values(scanInfos[si])))
+		run.ScanInfo = append(run.ScanInfo, xmlScanInfo{
+			Type:        si.scanType,
+			Protocol:    si.proto,
+			NumServices: len(ports),
+			Services:    servicesList(ports),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header+"<!DOCTYPE nmaprun>\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(run); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// summary is the sentence ending nmap outputs.
+func summary(end time.Time, hosts int, elapsed time.Duration) string {
+	return fmt.Sprintf("scan-exporter done at %s; %d IP address (%d host up) scanned in %.2f seconds",
+		end.Format(time.ANSIC), hosts, hosts, elapsed.Seconds())
+}
+
+// WriteNmapGrepable writes results in nmap's grepable format, as produced by
+// nmap -oG.
+func WriteNmapGrepable(w io.Writer, results []Result) error {
+	start, end := bounds(results)
+	hosts := nmapHosts(results)
+
+	lines := []string{fmt.Sprintf("# scan-exporter scan initiated %s", start.Format(time.ANSIC))}
+	for _, h := range hosts {
+		host := fmt.Sprintf("Host: %s (%s)", h.ip, h.name)
+		lines = append(lines, host+"\tStatus: Up")
+
+		ports := []string{}
+		for _, p := range h.ports {
+			ports = append(ports, fmt.Sprintf("%d/%s/%s//%s///", p.port, p.state, p.proto, p.service))
+		}
+		line := host + "\tPorts: " + strings.Join(ports, ", ")
+		for _, e := range h.extra {
+			line += fmt.Sprintf("\tIgnored State: %s (%d)", e.state, e.count)
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "# "+strings.Replace(summary(end, len(hosts), end.Sub(start)), ";", " --", 1))
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// NmapDir writes each result in its own file of a directory, in nmap's XML
+// or grepable format. Files older than maxAge are deleted, and only the
+// maxFiles most recent files of each target, IP and protocol are kept. Zero
+// values disable the limits.
+type NmapDir struct {
+	dir      string
+	grepable bool
+	maxAge   time.Duration
+	maxFiles int
+}
+
+// unsafeChars matches the characters replaced in file names.
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Write creates the file of the result, and deletes the files beyond the
+// limits. It is named after the target, IP, protocol and time of the scan, and
+// written atomically.
+func (d *NmapDir) Write(r Result) error {
+	ext, write := d.ext(), WriteNmapXML
+	if d.grepable {
+		write = WriteNmapGrepable
+	}
+	prefix := unsafeChars.ReplaceAllString(strings.Join([]string{r.Name, r.IP, r.Proto}, "_"), "-") + "_"
+	name := prefix + r.Time.Format(backupTimeFormat)
+
+	tmp, err := os.CreateTemp(d.dir, ".tmp-"+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp, []Result{r}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(d.dir, name+ext)); err != nil {
+		return err
+	}
+	return d.prune(prefix)
+}
+
+// ext returns the extension of the files of the directory.
+func (d *NmapDir) ext() string {
+	if d.grepable {
+		return ".gnmap"
+	}
+	return ".xml"
+}
+
+// prune deletes the files older than maxAge, and the oldest files starting
+// with prefix beyond maxFiles. Only the files named like the ones written by
+// Write are deleted.
+func (d *NmapDir) prune(prefix string) error {
+	if d.maxAge == 0 && d.maxFiles == 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+
+	// Entries are sorted by name, so the files of a prefix are sorted by time
+	kept := []string{}
+	for _, e := range entries {
+		written, ok := d.fileTime(e.Name())
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		if d.maxAge > 0 && time.Since(written) > d.maxAge {
+			if err := os.Remove(filepath.Join(d.dir, e.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(e.Name(), prefix) && len(e.Name()) == len(prefix)+len(backupTimeFormat)+len(d.ext()) {
+			kept = append(kept, e.Name())
+		}
+	}
+
+	for d.maxFiles > 0 && len(kept) > d.maxFiles {
+		if err := os.Remove(filepath.Join(d.dir, kept[0])); err != nil {
+			return err
+		}
+		kept = kept[1:]
+	}
+	return nil
+}
+
+// fileTime returns the time of the scan written in a file, from its name. ok is
+// false if name is not the name of a file written by Write.
+func (d *NmapDir) fileTime(name string) (written time.Time, ok bool) {
+	stem, found := strings.CutSuffix(name, d.ext())
+	if !found || len(stem) <= len(backupTimeFormat) || stem[len(stem)-len(backupTimeFormat)-1] != '_' {
+		return time.Time{}, false
+	}
+	written, err := time.ParseInLocation(backupTimeFormat, stem[len(stem)-len(backupTimeFormat):], time.Local)
+	return written, err == nil
+}
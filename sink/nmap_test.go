@@ -0,0 +1,202 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// nmapResults are a TCP and an UDP scan of the same IP, with many closed TCP
+// ports.
+func nmapResults() []Result {
+	start := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	tcp := Result{Start: start, Time: start.Add(2 * time.Second), Name: "app1", IP: "10.0.0.1", Proto: "tcp"}
+	for port := 1; port <= 30; port++ {
+		tcp.Ports = append(tcp.Ports, Port{Port: port, State: "closed", Expected: port == 25})
+	}
+	tcp.Ports = append(tcp.Ports, Port{Port: 443, State: "open", Banner: "HTTP/1.1 400 Bad Request", Service: "http",
+		HTTP: &HTTP{Status: 200, Server: "nginx", Title: "Welcome"}}, Port{Port: 8080, State: "filtered"})
+	udp := Result{Start: start, Time: start.Add(3 * time.Second), Name: "app1", IP: "10.0.0.1", Proto: "udp",
+		Ports: []Port{{Port: 53, State: "open|filtered"}}}
+	return []Result{tcp, udp}
+}
+
+func TestWriteNmapGrepable(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteNmapGrepable(&buf, nmapResults()); err != nil {
+		t.Fatalf("WriteNmapGrepable() error = %v", err)
+	}
+
+	want := "# scan-exporter scan initiated Thu Mar  4 10:00:00 2021\n" +
+		"Host: 10.0.0.1 (app1)\tStatus: Up\n" +
+		"Host: 10.0.0.1 (app1)\tPorts: 25/closed/tcp/////, 443/open/tcp//http///, 8080/filtered/tcp/////, 53/open|filtered/udp/////\tIgnored State: closed (29)\n" +
+		"# scan-exporter done at Thu Mar  4 10:00:03 2021 -- 1 IP address (1 host up) scanned in 3.00 seconds\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteNmapXML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteNmapXML(&buf, nmapResults()); err != nil {
+		t.Fatalf("WriteNmapXML() error = %v", err)
+	}
+
+	var run nmaprun
+	if err := xml.Unmarshal(buf.Bytes(), &run); err != nil {
+		t.Fatalf("cannot parse XML: %v\n%s", err, buf.String())
+	}
+
+	if len(run.ScanInfo) != 2 || run.ScanInfo[0].Services != "1-30,443,8080" || run.ScanInfo[1].Type != "udp" {
+		t.Errorf("got scaninfo %+v", run.ScanInfo)
+	}
+	if len(run.Hosts) != 1 {
+		t.Fatalf("got %d hosts, want 1", len(run.Hosts))
+	}
+	h := run.Hosts[0]
+	if h.Address.Addr != "10.0.0.1" || h.Hostnames[0].Name != "app1" || h.EndTime-h.StartTime != 3 {
+		t.Errorf("got host %+v", h)
+	}
+	if len(h.Ports.ExtraPorts) != 1 || h.Ports.ExtraPorts[0] != (xmlExtraPorts{State: "closed", Count: 29}) {
+		t.Errorf("got extraports %+v, want 29 closed", h.Ports.ExtraPorts)
+	}
+	if len(h.Ports.Ports) != 4 || h.Ports.Ports[1].PortID != 443 || h.Ports.Ports[1].State.State != "open" {
+		t.Errorf("got ports %+v", h.Ports.Ports)
+	}
+	wantScripts := []xmlScript{
+		{ID: "banner", Output: "HTTP/1.1 400 Bad Request"},
+		{ID: "http-title", Output: "Welcome"},
+		{ID: "http-server-header", Output: "nginx"},
+	}
+	if s := h.Ports.Ports[1].Scripts; !slices.Equal(s, wantScripts) {
+		t.Errorf("got scripts %+v, want %+v", s, wantScripts)
+	}
+	if s := h.Ports.Ports[1].Service; s == nil || s.Name != "http" {
+		t.Errorf("got service %+v, want http on port 443", s)
+	}
+}
+
+func TestWriteNmapXML_scanType(t *testing.T) {
+	start := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	result := func(ip, proto, method string) Result {
+		return Result{Start: start, Time: start.Add(time.Second), Name: "app1", IP: ip, Proto: proto, Method: method,
+			Ports: []Port{{Port: 22, State: "closed"}}}
+	}
+	tests := []struct {
+		name       string
+		results    []Result
+		want       []string
+		wantReason string
+	}{
+		{name: "connect", results: []Result{result("10.0.0.1", "tcp", "connect")}, want: []string{"tcp/connect"}, wantReason: "conn-refused"},
+		{name: "unknown method", results: []Result{result("10.0.0.1", "tcp", "")}, want: []string{"tcp/connect"}, wantReason: "conn-refused"},
+		{name: "syn", results: []Result{result("10.0.0.1", "tcp", "syn")}, want: []string{"tcp/syn"}, wantReason: "reset"},
+		{name: "udp", results: []Result{result("10.0.0.1", "udp", "")}, want: []string{"udp/udp"}, wantReason: "port-unreach"},
+		{
+			name:       "fallback",
+			results:    []Result{result("10.0.0.1", "tcp", "syn"), result("10.0.0.2", "tcp", "connect")},
+			want:       []string{"tcp/connect", "tcp/syn"},
+			wantReason: "reset",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteNmapXML(&buf, tt.results); err != nil {
+				t.Fatalf("WriteNmapXML() error = %v", err)
+			}
+			var run nmaprun
+			if err := xml.Unmarshal(buf.Bytes(), &run); err != nil {
+				t.Fatalf("cannot parse XML: %v\n%s", err, buf.String())
+			}
+
+			got := []string{}
+			for _, si := range run.ScanInfo {
+				got = append(got, si.Protocol+"/"+si.Type)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got scaninfo %v, want %v", got, tt.want)
+			}
+			if reason := run.Hosts[0].Ports.Ports[0].State.Reason; reason != tt.wantReason {
+				t.Errorf("got reason %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestNmapDir(t *testing.T) {
+	dir := t.TempDir()
+	d := NmapDir{dir: dir, grepable: true}
+	r := nmapResults()[0]
+	r.Name = "my app"
+	if err := d.Write(r); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "my-app_10.0.0.1_tcp_20210304T100002.000.gnmap"
+	if len(files) != 1 || files[0].Name() != want {
+		t.Fatalf("got files %v, want [%s]", files, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNmapDir_prune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	stamp := func(age time.Duration) string { return now.Add(-age).Format(backupTimeFormat) }
+	files := []string{
+		"app1_10.0.0.1_tcp_" + stamp(3*time.Hour) + ".xml",
+		"app1_10.0.0.1_tcp_" + stamp(2*time.Hour) + ".xml",
+		"app1_10.0.0.1_tcp_" + stamp(time.Hour) + ".xml",
+		"app1_10.0.0.1_udp_" + stamp(time.Hour) + ".xml",
+		"app2_10.0.0.2_tcp_" + stamp(48*time.Hour) + ".xml",
+		// Not written by the export
+		"app1_10.0.0.1_tcp_latest.xml",
+		"notes.xml",
+		"app2_10.0.0.2_tcp_" + stamp(48*time.Hour) + ".txt",
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := NmapDir{dir: dir, maxAge: 24 * time.Hour, maxFiles: 2}
+	r := nmapResults()[0]
+	r.Time = now
+	if err := d.Write(r); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	// The 2 most recent scans of 10.0.0.1 in TCP are kept, the old scan of
+	// app2 is deleted
+	want := []string{
+		"app1_10.0.0.1_tcp_" + stamp(time.Hour) + ".xml",
+		"app1_10.0.0.1_tcp_" + stamp(0) + ".xml",
+		"app1_10.0.0.1_tcp_latest.xml",
+		"app1_10.0.0.1_udp_" + stamp(time.Hour) + ".xml",
+		"app2_10.0.0.2_tcp_" + stamp(48*time.Hour) + ".txt",
+		"notes.xml",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+}
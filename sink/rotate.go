@@ -0,0 +1,241 @@
+package sink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/rs/zerolog/log"
+)
+
+// backupTimeFormat is the format of the timestamp added to rotated files. It
+// sorts in chronological order.
+const backupTimeFormat = "20060102T150405.000"
+
+// rotatingFile is a file that is renamed with a timestamp suffix and replaced by
+// a new one when it would exceed maxSize bytes, or when it is older than
+// maxAge. Only the maxBackups most recent rotated files are kept. Zero values
+// disable the limits.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	// header is written at the start of each new file
+	header []byte
+
+	f    *os.File
+	size int64
+	// created is when the file was created, or the best guess for files
+	// that existed when they were opened
+	created time.Time
+}
+
+// newRotatingFile creates the rotating file of an export, without opening it.
+func newRotatingFile(e config.Export) (*rotatingFile, error) {
+	f := rotatingFile{
+		path:       e.Path,
+		maxSize:    int64(e.MaxSize) * 1024 * 1024,
+		maxBackups: e.MaxBackups,
+	}
+	if e.Format == "csv" {
+		f.header = csvHeader()
+	}
+	maxAge, err := maxAge(e)
+	if err != nil {
+		return nil, err
+	}
+	f.maxAge = maxAge
+
+	return &f, nil
+}
+
+// maxAge returns the maximum age of the files of an export, and checks its
+// limits.
+func maxAge(e config.Export) (time.Duration, error) {
+	var maxAge time.Duration
+	if e.MaxAge != "" {
+		var err error
+		maxAge, err = time.ParseDuration(e.MaxAge)
+		if err != nil {
+			return 0, fmt.Errorf("invalid max age for export %s: %w", e.Path, err)
+		}
+	}
+	if e.MaxSize < 0 || e.MaxBackups < 0 || maxAge < 0 {
+		return 0, fmt.Errorf("max size, age and backups of export %s must not be negative", e.Path)
+	}
+	return maxAge, nil
+}
+
+// open opens the file for appending, creating it and its directory if needed.
+// An existing file starting with another header, written by another version,
+// is rotated so rows are not appended under the wrong columns. It must be
+// called with the lock held.
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = info.Size()
+	r.created = time.Now()
+	if r.size > 0 {
+		r.created = r.createdAt(info)
+	}
+
+	if len(r.header) == 0 {
+		return nil
+	}
+	if r.size > 0 {
+		ok, err := r.hasHeader()
+		if err != nil || ok {
+			return err
+		}
+		log.Warn().Msgf("%s has other columns, rotating it", r.path)
+		return r.rotate()
+	}
+	n, err := r.f.Write(r.header)
+	r.size += int64(n)
+	return err
+}
+
+// hasHeader reports whether the file starts with the header.
+func (r *rotatingFile) hasHeader() (bool, error) {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	b := make([]byte, len(r.header))
+	if _, err := io.ReadFull(f, b); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return bytes.Equal(b, r.header), nil
+}
+
+// write appends b to the file, rotating it first if needed. b is never split
+// between two files.
+func (r *rotatingFile) write(b []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+
+	// A file holding nothing but its header is not rotated
+	empty := r.size <= int64(len(r.header))
+	tooBig := r.maxSize > 0 && r.size+int64(len(b)) > r.maxSize
+	tooOld := r.maxAge > 0 && time.Since(r.created) >= r.maxAge
+	if !empty && (tooBig || tooOld) {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := r.f.Write(b)
+	r.size += int64(n)
+	return err
+}
+
+// rotate renames the current file, opens a new one and deletes the oldest
+// rotated files. It must be called with the lock held.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext)
+	backup := base + "-" + time.Now().Format(backupTimeFormat) + ext
+	if err := os.Rename(r.path, backup); err != nil {
+		return err
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	if r.maxBackups == 0 {
+		return nil
+	}
+	backups, err := r.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > r.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// createdAt guesses when an existing file was created, as its creation time is
+// not available everywhere. A file replaced the last rotated one when it was
+// rotated. Otherwise, it was created at the latest when it was last modified.
+func (r *rotatingFile) createdAt(info os.FileInfo) time.Time {
+	backups, err := r.backups()
+	if err != nil || len(backups) == 0 {
+		return info.ModTime()
+	}
+	rotated, _ := r.backupTime(filepath.Base(backups[len(backups)-1]))
+	return rotated
+}
+
+// backups returns the rotated files, oldest first. Only the files named like
+// the rotated ones, with a valid timestamp, are returned, so other files
+// sharing the name of the file are left alone.
+func (r *rotatingFile) backups() ([]string, error) {
+	dir := filepath.Dir(r.path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []string{}
+	for _, e := range entries {
+		if _, ok := r.backupTime(e.Name()); ok && e.Type().IsRegular() {
+			backups = append(backups, filepath.Join(dir, e.Name()))
+		}
+	}
+	slices.Sort(backups)
+	return backups, nil
+}
+
+// backupTime returns when a rotated file was rotated, from the timestamp in its
+// name. ok is false if name is not the name of a rotated file.
+func (r *rotatingFile) backupTime(name string) (rotated time.Time, ok bool) {
+	ext := filepath.Ext(r.path)
+	prefix := strings.TrimSuffix(filepath.Base(r.path), ext) + "-"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) || len(name) != len(prefix)+len(backupTimeFormat)+len(ext) {
+		return time.Time{}, false
+	}
+	rotated, err := time.ParseInLocation(backupTimeFormat, name[len(prefix):len(name)-len(ext)], time.Local)
+	return rotated, err == nil
+}
@@ -0,0 +1,172 @@
+package sink
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_rotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "results.csv")
+	r := rotatingFile{path: path, maxSize: 20, maxBackups: 2, header: []byte("header\n")}
+	// Files sharing the name of the export are not backups
+	for _, name := range []string{"results-old.csv", "results-2021.csv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each write after the first one exceeds the maximum size, and rotates the
+	// file
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		if err := r.write([]byte(line)); err != nil {
+			t.Fatalf("write() error = %v", err)
+		}
+		// Make sure backups get different names
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "header\nline 4\n" {
+		t.Errorf("got current file %q, want the header and line 4", b)
+	}
+
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("got backups %v, want 2", backups)
+	}
+	for i, want := range []string{"header\nline 2\n", "header\nline 3\n"} {
+		b, err := os.ReadFile(backups[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("got backup %s = %q, want %q", backups[i], b, want)
+		}
+	}
+	for _, name := range []string{"results-old.csv", "results-2021.csv"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was deleted: %v", name, err)
+		}
+	}
+
+	// Rotation by age
+	r.maxSize = 0
+	r.maxAge = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	if err := r.write([]byte("line 5\n")); err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), "line 5\n") || strings.Contains(string(b), "line 4") {
+		t.Errorf("got current file %q, want it rotated before line 5", b)
+	}
+}
+
+func Test_rotatingFile_header(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		wantRotated bool
+	}{
+		{name: "same header", file: "a,b\n1,2\n"},
+		{name: "other header", file: "a\n1\n", wantRotated: true},
+		{name: "longer header", file: "a,b,c\n1,2,3\n", wantRotated: true},
+		{name: "truncated header", file: "a,", wantRotated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "results.csv")
+			if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			r := rotatingFile{path: path, header: []byte("a,b\n")}
+			if err := r.write([]byte("3,4\n")); err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.file + "3,4\n"
+			if tt.wantRotated {
+				want = "a,b\n3,4\n"
+			}
+			if string(b) != want {
+				t.Errorf("got current file %q, want %q", b, want)
+			}
+			backups, err := r.backups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rotated := len(backups) == 1; rotated != tt.wantRotated {
+				t.Errorf("got backups %v, want rotated %v", backups, tt.wantRotated)
+			}
+		})
+	}
+}
+
+func Test_rotatingFile_age(t *testing.T) {
+	tests := []struct {
+		name string
+		// backup is the age of the last rotated file, none if 0
+		backup time.Duration
+		// modified is the age of the last change of the file
+		modified    time.Duration
+		wantRotated bool
+	}{
+		{name: "recent file", modified: time.Minute},
+		{name: "old file", modified: 2 * time.Hour, wantRotated: true},
+		{name: "recently modified file rotated long ago", backup: 2 * time.Hour, modified: time.Minute, wantRotated: true},
+		{name: "recently rotated file", backup: 30 * time.Minute, modified: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "results.jsonl")
+			if err := os.WriteFile(path, []byte("line 1\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			modified := time.Now().Add(-tt.modified)
+			if err := os.Chtimes(path, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+			if tt.backup > 0 {
+				name := "results-" + time.Now().Add(-tt.backup).Format(backupTimeFormat) + ".jsonl"
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// As after a restart, the age of the file is kept
+			r := rotatingFile{path: path, maxAge: time.Hour}
+			if err := r.write([]byte("line 2\n")); err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rotated := string(b) == "line 2\n"; rotated != tt.wantRotated {
+				t.Errorf("got current file %q, want rotated %v", b, tt.wantRotated)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Package sink exports the raw results of every completed scan, so they can be
+// ingested by other tools.
+package sink
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/devops-works/scan-exporter/config"
+)
+
+// Port is the state of a scanned port. Banner is the first line sent by the
+// service and Service what it has been identified as, when the port has been
+// probed. HTTP is set when the port has been probed with an HTTP request.
+// Latency is the time it took to connect to the port, or to be refused or
+// answered, and Reason why the port is not open, if it is not.
+type Port struct {
+	Port     int     `json:"port"`
+	State    string  `json:"state"`
+	Expected bool    `json:"expected"`
+	Latency  float64 `json:"latency_seconds,omitempty"`
+	Reason   string  `json:"reason,omitempty"`
+	Banner   string  `json:"banner,omitempty"`
+	Service  string  `json:"service,omitempty"`
+	HTTP     *HTTP   `json:"http,omitempty"`
+}
+
+// HTTP is the answer to the HTTP probe of a port. Location is the redirect
+// target, if any. Error is set when no answer was received, and Unexpected
+// lists the differences with the expected answer.
+type HTTP struct {
+	Status          int      `json:"status,omitempty"`
+	Location        string   `json:"location,omitempty"`
+	Server          string   `json:"server,omitempty"`
+	Title           string   `json:"title,omitempty"`
+	ResponseSeconds float64  `json:"response_seconds,omitempty"`
+	Error           string   `json:"error,omitempty"`
+	Unexpected      []string `json:"unexpected,omitempty"`
+}
+
+// Probe is what has been learned about a probed port.
+type Probe struct {
+	Banner  string
+	Service string
+	HTTP    *HTTP
+}
+
+// Result is a completed scan of an IP of a target using a protocol. Start is
+// the time the scan began, Time the time it completed. Method is how TCP ports
+// were scanned, connect or syn, and is empty for UDP scans.
+type Result struct {
+	Start  time.Time `json:"start"`
+	Time   time.Time `json:"time"`
+	Name   string    `json:"name"`
+	IP     string    `json:"ip"`
+	Proto  string    `json:"proto"`
+	Method string    `json:"method,omitempty"`
+	Ports  []Port    `json:"ports"`
+}
+
+// NewResult builds the result of a scan started at start and completed now,
+// from the scanned ports and what has been learned about the probed ones, by
+// port. Ports are sorted by number.
+func NewResult(name, ip, proto, method string, start time.Time, ports []Port, probes map[string]Probe) Result {
+	r := Result{
+		Start:  start,
+		Time:   time.Now(),
+		Name:   name,
+		IP:     ip,
+		Proto:  proto,
+		Method: method,
+		Ports:  []Port{},
+	}
+	for _, p := range ports {
+		pr := probes[strconv.Itoa(p.Port)]
+		p.Banner, p.Service, p.HTTP = pr.Banner, pr.Service, pr.HTTP
+		r.Ports = append(r.Ports, p)
+	}
+	slices.SortFunc(r.Ports, func(a, b Port) int {
+		return cmp.Compare(a.Port, b.Port)
+	})
+
+	return r
+}
+
+// Sink receives the result of every completed scan. Write is called by the
+// scanner's receiver, so it should not block for long.
+type Sink interface {
+	Write(r Result) error
+}
+
+// Open creates the sinks of the configuration, and opens their files.
+func Open(exports []config.Export) ([]Sink, error) {
+	return build(exports, true)
+}
+
+// Check reports the first error of the configuration, without opening any
+// file.
+func Check(exports []config.Export) error {
+	_, err := build(exports, false)
+	return err
+}
+
+// build creates the sinks of the configuration. Their files are only opened if
+// open is set.
+func build(exports []config.Export, open bool) ([]Sink, error) {
+	sinks := []Sink{}
+	for _, e := range exports {
+		if e.Path == "" {
+			return nil, fmt.Errorf("export without path")
+		}
+
+		switch e.Format {
+		case "jsonl", "csv":
+			f, err := newRotatingFile(e)
+			if err != nil {
+				return nil, err
+			}
+			if open {
+				if err := f.open(); err != nil {
+					return nil, err
+				}
+			}
+			if e.Format == "csv" {
+				sinks = append(sinks, &CSV{file: f})
+			} else {
+				sinks = append(sinks, &JSONLines{file: f})
+			}
+		case "nmap-xml", "nmap-grepable":
+			maxAge, err := maxAge(e)
+			if err != nil {
+				return nil, err
+			}
+			d := NmapDir{dir: e.Path, grepable: e.Format == "nmap-grepable", maxAge: maxAge, maxFiles: e.MaxBackups}
+			if open {
+				if err := os.MkdirAll(d.dir, 0o755); err != nil {
+					return nil, err
+				}
+			}
+			sinks = append(sinks, &d)
+		default:
+			return nil, fmt.Errorf("unknown format %q for export %s, must be jsonl, csv, nmap-xml or nmap-grepable", e.Format, e.Path)
+		}
+	}
+
+	return sinks, nil
+}
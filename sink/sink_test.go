@@ -0,0 +1,120 @@
+package sink
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devops-works/scan-exporter/config"
+)
+
+func TestNewResult(t *testing.T) {
+	r := NewResult("app1", "10.0.0.1", "tcp", "syn", time.Now(), []Port{
+		{Port: 443, State: "open", Latency: 0.002},
+		{Port: 22, State: "open", Expected: true, Latency: 0.001},
+		{Port: 80, State: "closed", Expected: true, Reason: "refused"},
+	}, map[string]Probe{
+		"22":  {Banner: "SSH-2.0-OpenSSH_9.6", Service: "ssh"},
+		"443": {Service: "tls", HTTP: &HTTP{Status: 200, Title: "Home"}},
+	})
+
+	want := []Port{
+		{Port: 22, State: "open", Expected: true, Latency: 0.001, Banner: "SSH-2.0-OpenSSH_9.6", Service: "ssh"},
+		{Port: 80, State: "closed", Expected: true, Reason: "refused"},
+		{Port: 443, State: "open", Expected: false, Latency: 0.002, Service: "tls", HTTP: &HTTP{Status: 200, Title: "Home"}},
+	}
+	if !reflect.DeepEqual(r.Ports, want) {
+		t.Errorf("NewResult() ports = %v, want %v", r.Ports, want)
+	}
+	if r.Method != "syn" {
+		t.Errorf("NewResult() method = %q, want syn", r.Method)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	sinks, err := Open([]config.Export{
+		{Format: "jsonl", Path: filepath.Join(dir, "results.jsonl")},
+		{Format: "csv", Path: filepath.Join(dir, "csv", "results.csv")},
+	})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	r := Result{
+		Time:  time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC),
+		Name:  "app1",
+		IP:    "10.0.0.1",
+		Proto: "tcp",
+		Ports: []Port{
+			{Port: 22, State: "open", Expected: true, Latency: 0.0015, Banner: "SSH-2.0-OpenSSH_9.6", Service: "ssh"},
+			{Port: 80, State: "closed", Reason: "refused"},
+			{Port: 443, State: "open", Service: "tls", HTTP: &HTTP{Status: 301, Location: "https://example.com/", Server: "nginx", ResponseSeconds: 0.0125, Unexpected: []string{"status 301, want 200"}}},
+		},
+	}
+	for _, s := range sinks {
+		for range 2 {
+			if err := s.Write(r); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "results.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d JSON lines, want 2", len(lines))
+	}
+	var got Result
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, r) {
+		t.Errorf("got %+v, want %+v", got, r)
+	}
+
+	b, err = os.ReadFile(filepath.Join(dir, "csv", "results.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `time,name,ip,proto,port,state,expected,banner,service,http_status,http_location,http_server,http_title,http_seconds,latency_seconds,reason
+2021-03-04T10:00:00Z,app1,10.0.0.1,tcp,22,open,true,SSH-2.0-OpenSSH_9.6,ssh,,,,,,0.001500,
+2021-03-04T10:00:00Z,app1,10.0.0.1,tcp,80,closed,false,,,,,,,,0.000000,refused
+2021-03-04T10:00:00Z,app1,10.0.0.1,tcp,443,open,false,,tls,301,https://example.com/,nginx,,0.013,0.000000,
+2021-03-04T10:00:00Z,app1,10.0.0.1,tcp,22,open,true,SSH-2.0-OpenSSH_9.6,ssh,,,,,,0.001500,
+2021-03-04T10:00:00Z,app1,10.0.0.1,tcp,80,closed,false,,,,,,,,0.000000,refused
+2021-03-04T10:00:00Z,app1,10.0.0.1,tcp,443,open,false,,tls,301,https://example.com/,nginx,,0.013,0.000000,
+`
+	if string(b) != want {
+		t.Errorf("got CSV\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		exports []config.Export
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "valid", exports: []config.Export{{Format: "csv", Path: "results.csv", MaxSize: 10, MaxAge: "24h", MaxBackups: 7}}},
+		{name: "no path", exports: []config.Export{{Format: "csv"}}, wantErr: true},
+		{name: "unknown format", exports: []config.Export{{Format: "xml", Path: "results.xml"}}, wantErr: true},
+		{name: "invalid max age", exports: []config.Export{{Format: "jsonl", Path: "results.jsonl", MaxAge: "1d"}}, wantErr: true},
+		{name: "negative max size", exports: []config.Export{{Format: "jsonl", Path: "results.jsonl", MaxSize: -1}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Check(tt.exports); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// FileStore is a Store persisted in a JSON file, so the results of the previous
+// scans survive restarts. The file is rewritten on each change.
+type FileStore struct {
+	path  string
+	store Store
+}
+
+// OpenFile loads the store from path. The file is created on the first change
+// if it does not exist.
+func OpenFile(path string) (*FileStore, error) {
+	f := FileStore{
+		path:  path,
+		store: Create(),
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &f.store); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// Get the values associated to a key from the store
+func (f *FileStore) Get(k string) []string {
+	return f.store.Get(k)
+}
+
+// Update a value in the store and save it
+func (f *FileStore) Update(k string, v []string) {
+	f.store.Update(k, v)
+	f.save()
+}
+
+// Delete a key from the store and save it
+func (f *FileStore) Delete(k string) {
+	f.store.Delete(k)
+	f.save()
+}
+
+// save writes the store to its file.
+func (f *FileStore) save() {
+	if err := writeJSON(f.path, f.store); err != nil {
+		log.Error().Err(err).Msgf("cannot save store to %s", f.path)
+	}
+}
+
+// writeJSON encodes v in a temporary file and renames it to path, so the file
+// is never left half-written.
+func writeJSON(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile() on missing file error = %v", err)
+	}
+	f.Update("tcp/10.0.0.1", []string{"22", "443"})
+	f.Update("tcp/10.0.0.2", []string{"80"})
+	f.Update("tcp/10.0.0.3", []string{})
+	f.Delete("tcp/10.0.0.2")
+
+	// Reopen, as after a restart
+	f, err = OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	if got, want := f.Get("tcp/10.0.0.1"), []string{"22", "443"}; !equal(got, want) {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if got := f.Get("tcp/10.0.0.2"); got != nil {
+		t.Errorf("got %q want nothing", got)
+	}
+	// No open port is a baseline, unlike no scan
+	if got := f.Get("tcp/10.0.0.3"); got == nil || len(got) != 0 {
+		t.Errorf("got %#v want an empty list", got)
+	}
+
+	// No temporary file is left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files in store directory, want 1", len(entries))
+	}
+}
+
+func TestOpenFile_invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(path); err == nil {
+		t.Errorf("OpenFile() on invalid file error = nil, want an error")
+	}
+}
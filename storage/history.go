@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultHistoryLength is the number of entries kept for each target, IP and
+// protocol when no length is given.
+const DefaultHistoryLength = 100
+
+// Entry is the result of a scan, with the ports that appeared and disappeared
+// since the previous one. Banners and Services hold the banners and services
+// of the probed open ports, by port.
+type Entry struct {
+	Time     time.Time         `json:"time"`
+	IP       string            `json:"ip"`
+	Proto    string            `json:"proto"`
+	Open     []string          `json:"open"`
+	Opened   []string          `json:"opened"`
+	Closed   []string          `json:"closed"`
+	Banners  map[string]string `json:"banners,omitempty"`
+	Services map[string]string `json:"services,omitempty"`
+}
+
+// record is a line of the history file: either an entry added to the history
+// of a target, or the deletion of the history of one of its IPs, or only of
+// one of its protocols when Proto is set.
+type record struct {
+	Name    string `json:"name"`
+	Entry   *Entry `json:"entry,omitempty"`
+	Deleted string `json:"deleted,omitempty"`
+	Proto   string `json:"proto,omitempty"`
+}
+
+// History holds the last scan results of each target, indexed by target name.
+// It is safe for concurrent use. When path is set, it is persisted in a JSON
+// lines file where each change is appended. The file is compacted in the
+// background once it holds more than twice the entries kept.
+type History struct {
+	mu      sync.RWMutex
+	path    string
+	length  int
+	entries map[string][]Entry
+
+	// file is the history file, opened for appending, and lines the number
+	// of records it holds
+	file  *os.File
+	lines int
+	// compacting is set while the file is compacted, and pending holds the
+	// records appended in the meantime, which are copied to the new file
+	compacting  bool
+	pending     []record
+	compactions sync.WaitGroup
+}
+
+// NewHistory creates a history keeping length entries for each target, IP and
+// protocol. If path is set, the history is loaded from it and saved to it.
+func NewHistory(path string, length int) (*History, error) {
+	if length <= 0 {
+		length = DefaultHistoryLength
+	}
+
+	h := History{
+		path:    path,
+		length:  length,
+		entries: make(map[string][]Entry),
+	}
+
+	if path == "" {
+		return &h, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err := h.load(b); err != nil {
+		return nil, err
+	}
+
+	// Start from a compacted file
+	tmp, err := writeRecords(path, h.records())
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if h.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return nil, err
+	}
+	h.lines = h.len()
+
+	return &h, nil
+}
+
+// load replays the records of a history file. A truncated last record, left
+// by a crash while it was written, is ignored.
+func (h *History) load(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for {
+		var r record
+		err := dec.Decode(&r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Warn().Msgf("ignoring truncated last record of history %s", h.path)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r.Entry != nil {
+			h.add(r.Name, *r.Entry)
+		} else {
+			h.remove(r.Name, r.Deleted, r.Proto)
+		}
+	}
+}
+
+// Add appends an entry to the history of a target, and drops the oldest entries
+// of the same IP and protocol beyond the history length.
+func (h *History) Add(name string, e Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.add(name, e)
+	h.append(record{Name: name, Entry: &e})
+}
+
+// add appends an entry to the history of a target, and applies the history
+// length. It must be called with the lock held.
+func (h *History) add(name string, e Entry) {
+	entries := append(h.entries[name], e)
+
+	// Count from the most recent entry, and drop the ones in excess
+	count := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].IP != e.IP || entries[i].Proto != e.Proto {
+			continue
+		}
+		count++
+		if count > h.length {
+			entries = slices.Delete(entries, i, i+1)
+		}
+	}
+	h.entries[name] = entries
+}
+
+// Get returns the history of a target, oldest entry first.
+func (h *History) Get(name string) []Entry {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return slices.Clone(h.entries[name])
+}
+
+// Delete drops the history of one of the IPs of a target, or only the entries
+// of proto when it is set.
+func (h *History) Delete(name, ip, proto string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(name, ip, proto)
+	h.append(record{Name: name, Deleted: ip, Proto: proto})
+}
+
+// remove drops the history of one of the IPs of a target, or only the entries
+// of proto when it is set. It must be called with the lock held.
+func (h *History) remove(name, ip, proto string) {
+	entries := slices.DeleteFunc(h.entries[name], func(e Entry) bool {
+		return e.IP == ip && (proto == "" || e.Proto == proto)
+	})
+	if len(entries) == 0 {
+		delete(h.entries, name)
+	} else {
+		h.entries[name] = entries
+	}
+}
+
+// len returns the number of entries in the history. It must be called with
+// the lock held.
+func (h *History) len() int {
+	n := 0
+	for _, entries := range h.entries {
+		n += len(entries)
+	}
+	return n
+}
+
+// records returns the records rebuilding the history, by target name. It must
+// be called with the lock held.
+func (h *History) records() []record {
+	records := []record{}
+	for _, name := range slices.Sorted(maps.Keys(h.entries)) {
+		for _, e := range h.entries[name] {
+			records = append(records, record{Name: name, Entry: &e})
+		}
+	}
+	return records
+}
+
+// append writes a record at the end of the history file, if any, and starts
+// a compaction when the file has grown too much. It must be called with the
+// lock held.
+func (h *History) append(r record) {
+	if h.file == nil {
+		return
+	}
+	if err := writeRecord(h.file, r); err != nil {
+		log.Error().Err(err).Msgf("cannot save history to %s", h.path)
+		return
+	}
+	h.lines++
+
+	if h.compacting {
+		h.pending = append(h.pending, r)
+		return
+	}
+	if h.lines > 2*max(h.len(), h.length) {
+		h.compacting = true
+		h.compactions.Add(1)
+		go h.compact(h.records())
+	}
+}
+
+// compact replaces the history file by one holding only records, the current
+// history, and the records appended since they were taken.
+func (h *History) compact(records []record) {
+	defer h.compactions.Done()
+
+	tmp, err := writeRecords(h.path, records)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pending := h.pending
+	h.compacting, h.pending = false, nil
+	if err != nil {
+		log.Error().Err(err).Msgf("cannot compact history %s", h.path)
+		return
+	}
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err == nil {
+		for _, r := range pending {
+			if err = writeRecord(f, r); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, h.path)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("cannot compact history %s", h.path)
+		if f != nil {
+			f.Close()
+		}
+		os.Remove(tmp)
+		return
+	}
+
+	h.file.Close()
+	h.file = f
+	h.lines = len(records) + len(pending)
+}
+
+// writeRecord writes a record on its own line.
+func writeRecord(w io.Writer, r record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// writeRecords writes records to a temporary file next to path, and returns
+// its name.
+func writeRecords(path string, records []record) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, r := range records {
+		if err = writeRecord(w, r); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	h, err := NewHistory(path, 2)
+	if err != nil {
+		t.Fatalf("NewHistory() error = %v", err)
+	}
+
+	now := time.Now()
+	h.Add("app1", Entry{Time: now, IP: "10.0.0.1", Proto: "tcp", Open: []string{"22"}})
+	h.Add("app1", Entry{Time: now.Add(time.Minute), IP: "10.0.0.2", Proto: "tcp", Open: []string{"80"}})
+	h.Add("app1", Entry{Time: now.Add(2 * time.Minute), IP: "10.0.0.1", Proto: "tcp", Open: []string{"22", "443"}})
+	h.Add("app1", Entry{Time: now.Add(3 * time.Minute), IP: "10.0.0.1", Proto: "tcp", Open: []string{"443"}})
+	h.Add("app2", Entry{Time: now, IP: "10.0.0.3", Proto: "udp"})
+
+	// The oldest entry of 10.0.0.1 is dropped, 10.0.0.2 is kept
+	got := h.Get("app1")
+	if len(got) != 3 {
+		t.Fatalf("Get() returned %d entries, want 3", len(got))
+	}
+	if got[0].IP != "10.0.0.2" || !equal(got[1].Open, []string{"22", "443"}) || !equal(got[2].Open, []string{"443"}) {
+		t.Errorf("Get() = %v, want the last two entries of 10.0.0.1 and the one of 10.0.0.2", got)
+	}
+
+	h.Delete("app1", "10.0.0.2", "")
+	h.Add("app1", Entry{Time: now.Add(4 * time.Minute), IP: "10.0.0.1", Proto: "udp", Open: []string{"53"}})
+	h.Delete("app1", "10.0.0.1", "udp")
+
+	// Reload from file, as after a restart
+	h, err = NewHistory(path, 2)
+	if err != nil {
+		t.Fatalf("NewHistory() error = %v", err)
+	}
+	if got := h.Get("app1"); len(got) != 2 || got[0].IP != "10.0.0.1" {
+		t.Errorf("Get() after reload = %v, want the 2 entries of 10.0.0.1", got)
+	}
+	if got := h.Get("app2"); len(got) != 1 {
+		t.Errorf("Get() after reload = %v, want 1 entry", got)
+	}
+	if got := h.Get("unknown"); len(got) != 0 {
+		t.Errorf("Get() = %v, want nothing", got)
+	}
+}
+
+func TestHistory_compaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	h, err := NewHistory(path, 2)
+	if err != nil {
+		t.Fatalf("NewHistory() error = %v", err)
+	}
+	now := time.Now()
+	for i := range 50 {
+		h.Add("app1", Entry{Time: now.Add(time.Duration(i) * time.Minute), IP: "10.0.0.1", Proto: "tcp", Open: []string{strconv.Itoa(i)}})
+		h.compactions.Wait()
+	}
+
+	// Only the records of the last entries are kept, at most twice the
+	// history length
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(b, []byte("\n")); lines > 4 {
+		t.Errorf("history file holds %d records, want at most 4", lines)
+	}
+
+	h, err = NewHistory(path, 2)
+	if err != nil {
+		t.Fatalf("NewHistory() error = %v", err)
+	}
+	if got := h.Get("app1"); len(got) != 2 || !equal(got[0].Open, []string{"48"}) || !equal(got[1].Open, []string{"49"}) {
+		t.Errorf("Get() after reload = %v, want the last 2 entries", got)
+	}
+}
+
+func TestNewHistory_formats(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want []Entry
+	}{
+		{
+			name: "json lines",
+			file: `{"name":"app1","entry":{"time":"2021-03-04T10:00:00Z","ip":"10.0.0.1","proto":"tcp","open":["22"]}}
+{"name":"app1","entry":{"time":"2021-03-04T10:00:00Z","ip":"10.0.0.2","proto":"tcp","open":["80"]}}
+{"name":"app1","deleted":"10.0.0.2"}
+`,
+			want: []Entry{{IP: "10.0.0.1", Proto: "tcp", Open: []string{"22"}}},
+		},
+		{
+			name: "truncated last record",
+			file: `{"name":"app1","entry":{"time":"2021-03-04T10:00:00Z","ip":"10.0.0.1","proto":"tcp","open":["22"]}}
+{"name":"app1","entry":{"time":"2021-03-04T10:`,
+			want: []Entry{{IP: "10.0.0.1", Proto: "tcp", Open: []string{"22"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "history.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			h, err := NewHistory(path, 2)
+			if err != nil {
+				t.Fatalf("NewHistory() error = %v", err)
+			}
+			got := h.Get("app1")
+			if len(got) != len(tt.want) {
+				t.Fatalf("Get() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].IP != tt.want[i].IP || got[i].Proto != tt.want[i].Proto || !equal(got[i].Open, tt.want[i].Open) {
+					t.Errorf("Get()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
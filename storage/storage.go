@@ -1,5 +1,23 @@
 package storage
 
+// Backend holds the last open ports of each target. Get returns nil for the
+// targets that have not been scanned yet, and an empty list for the ones
+// without open ports. Store is the in-memory implementation, FileStore the
+// persistent one.
+type Backend interface {
+	Get(k string) []string
+	Update(k string, v []string)
+	Delete(k string)
+}
+
+// Open returns a FileStore if path is set, an in-memory Store otherwise.
+func Open(path string) (Backend, error) {
+	if path == "" {
+		return Create(), nil
+	}
+	return OpenFile(path)
+}
+
 // Store is a key/value
 type Store map[string][]string
 
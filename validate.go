@@ -0,0 +1,84 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/devops-works/scan-exporter/config"
+	"github.com/devops-works/scan-exporter/notifier"
+	"github.com/devops-works/scan-exporter/scan"
+	"github.com/devops-works/scan-exporter/sink"
+)
+
+// errInvalidConfig is returned by the validate subcommand when the
+// configuration has errors.
+var errInvalidConfig = errors.New("invalid configuration")
+
+// runValidate is the validate subcommand. It checks a configuration file and
+// prints all its errors, with their line.
+func runValidate(args []string, stdout io.Writer) error {
+	var confFile string
+
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.StringVar(&confFile, "config", "config.yaml", "path to config file")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	errs := validateConfig(confFile)
+	for _, err := range errs {
+		if err.Line == 0 {
+			fmt.Fprintf(stdout, "%s: %s\n", confFile, err.Err)
+		} else {
+			fmt.Fprintf(stdout, "%s:%d: %s\n", confFile, err.Line, err.Err)
+		}
+	}
+
+	if len(errs) > 0 {
+		fmt.Fprintf(stdout, "%d error(s) found in %s\n", len(errs), confFile)
+		return errInvalidConfig
+	}
+	fmt.Fprintf(stdout, "%s is valid\n", confFile)
+	return nil
+}
+
+// validateConfig returns all the errors of a configuration file, sorted by
+// line.
+func validateConfig(f string) []*config.Error {
+	c, lines, errs := config.NewStrict(f)
+	if c == nil {
+		return errs
+	}
+
+	for _, err := range scan.Validate(c) {
+		// Imported targets are not in the file
+		line := 0
+		if err.Source == "" {
+			line = lines.Line(err.Field)
+		}
+		errs = append(errs, &config.Error{Line: line, Err: err})
+	}
+
+	// The notifiers and sinks check their own settings
+	if err := notifier.ValidateWebhooks(c.Notifications); err != nil {
+		errs = append(errs, &config.Error{Line: lines.Line("notifications.webhooks"), Err: err})
+	}
+	if err := notifier.ValidateAlertmanager(c.Notifications); err != nil {
+		errs = append(errs, &config.Error{Line: lines.Line("notifications.alertmanager"), Err: err})
+	}
+	if err := sink.Check(c.Exports); err != nil {
+		errs = append(errs, &config.Error{Line: lines.Line("exports"), Err: err})
+	}
+
+	slices.SortStableFunc(errs, func(a, b *config.Error) int {
+		return cmp.Compare(a.Line, b.Line)
+	})
+	return errs
+}